@@ -0,0 +1,193 @@
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/request"
+)
+
+// Access log formats, the extended format is the combined format followed by
+// the request ID, the workspace, the exec flag and the duration in microseconds
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatExtended = "extended"
+	FormatJSON     = "json"
+)
+
+// Logger writes a log entry for each request served by the handler
+type Logger struct {
+	format string
+	out    io.Writer
+	json   zerolog.Logger
+	mtx    sync.Mutex
+}
+
+// New creates a new access logger from the configuration, the file may be
+// 'stdout', 'stderr' or a path relative to the given directory. If the
+// configuration is nil then nil is returned, which is a valid logger
+// that does not log.
+func New(c *viper.Viper, dir string) (*Logger, error) {
+	if c == nil || (c.IsSet("enabled") && !c.GetBool("enabled")) {
+		return nil, nil
+	}
+
+	format := strings.ToLower(c.GetString("format"))
+	switch format {
+	case "":
+		format = FormatCombined
+	case FormatCommon, FormatCombined, FormatExtended, FormatJSON:
+	default:
+		return nil, fmt.Errorf("Access log format not supported: %s", format)
+	}
+
+	var out io.Writer
+	switch file := c.GetString("file"); file {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		maxBackups := 5
+		if c.IsSet("maxBackups") {
+			maxBackups = c.GetInt("maxBackups")
+		}
+		f, err := OpenRotatingFile(file, int64(c.GetSizeInBytes("maxSize")), maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	return NewWriter(format, out), nil
+}
+
+// NewWriter creates a new access logger that writes to the writer
+func NewWriter(format string, out io.Writer) *Logger {
+	return &Logger{
+		format: format,
+		out:    out,
+		json:   zerolog.New(out),
+	}
+}
+
+// Handler returns a handler that logs the requests served by the handler
+func (l *Logger) Handler(handler http.Handler) http.Handler {
+	if l == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx, info := request.WithInfo(req.Context())
+		req = req.WithContext(ctx)
+
+		start := time.Now()
+		rec := &request.Recorder{ResponseWriter: res}
+		handler.ServeHTTP(rec, req)
+		l.log(req, rec, info, start)
+	})
+}
+
+func (l *Logger) log(req *http.Request, rec *request.Recorder, info *request.Info, start time.Time) {
+	duration := time.Since(start)
+
+	id := info.ID
+	if id == "" {
+		id = req.Header.Get("X-Request-ID")
+	}
+
+	user := "-"
+	if p := auth.Ctx(req.Context()); !p.IsAnonymous() {
+		user = p.Name
+	}
+
+	if l.format == FormatJSON {
+		l.json.Log().
+			Time("time", start).
+			Str("remote", remoteHost(req)).
+			Str("user", user).
+			Str("method", req.Method).
+			Str("uri", req.RequestURI).
+			Str("proto", req.Proto).
+			Int("status", rec.Status()).
+			Int64("bytes", rec.Bytes).
+			Dur("duration", duration).
+			Str("referer", req.Referer()).
+			Str("agent", req.UserAgent()).
+			Str("workspace", info.Workspace).
+			Bool("exec", info.Exec).
+			Str("id", id).
+			Send()
+		return
+	}
+
+	b := strings.Builder{}
+	b.WriteString(remoteHost(req))
+	b.WriteString(" - ")
+	b.WriteString(user)
+	b.WriteString(start.Format(" [02/Jan/2006:15:04:05 -0700] "))
+	b.WriteString(strconv.Quote(req.Method + " " + req.RequestURI + " " + req.Proto))
+	b.WriteString(" ")
+	b.WriteString(strconv.Itoa(rec.Status()))
+	b.WriteString(" ")
+	if rec.Bytes > 0 {
+		b.WriteString(strconv.FormatInt(rec.Bytes, 10))
+	} else {
+		b.WriteString("-")
+	}
+	if l.format == FormatCombined || l.format == FormatExtended {
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(orDash(req.Referer())))
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(orDash(req.UserAgent())))
+	}
+	// Extension fields following the standard fields
+	if l.format == FormatExtended {
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(orDash(id)))
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(orDash(info.Workspace)))
+		b.WriteString(" ")
+		b.WriteString(strconv.FormatBool(info.Exec))
+		b.WriteString(" ")
+		b.WriteString(strconv.FormatInt(duration.Microseconds(), 10))
+	}
+	b.WriteString("\n")
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	io.WriteString(l.out, b.String())
+}
+
+// orDash returns the value, or '-' if the value is empty
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if host == "" || host == "@" {
+		return "-"
+	}
+	return host
+}
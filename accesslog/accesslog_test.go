@@ -0,0 +1,92 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/makeshiftd/makeshiftd/request"
+)
+
+var testHandler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+	info := request.Ctx(req.Context())
+	info.Workspace = "ws1"
+	info.Exec = true
+	res.WriteHeader(http.StatusCreated)
+	res.Write([]byte("Hello"))
+})
+
+func TestFormats(t *testing.T) {
+	table := []struct {
+		Format  string
+		Pattern string
+	}{
+		{Format: FormatCommon, Pattern: `^192\.0\.2\.1 - - \[[^\]]+\] "PUT /ws1/data\.json HTTP/1\.1" 201 5\n$`},
+		{Format: FormatCombined, Pattern: `^192\.0\.2\.1 - - \[[^\]]+\] "PUT /ws1/data\.json HTTP/1\.1" 201 5 "-" "test"\n$`},
+		{Format: FormatExtended, Pattern: `^192\.0\.2\.1 - - \[[^\]]+\] "PUT /ws1/data\.json HTTP/1\.1" 201 5 "-" "test" "abc123" "ws1" true \d+\n$`},
+	}
+
+	for _, row := range table {
+		t.Run(row.Format, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			handler := NewWriter(row.Format, out).Handler(testHandler)
+
+			req := httptest.NewRequest("PUT", "/ws1/data.json", nil)
+			req.Header.Set("X-Request-ID", "abc123")
+			req.Header.Set("User-Agent", "test")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.Regexp(t, regexp.MustCompile(row.Pattern), out.String())
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	handler := NewWriter(FormatJSON, out).Handler(testHandler)
+
+	req := httptest.NewRequest("GET", "/ws1/data.json", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entry := map[string]interface{}{}
+	err := json.Unmarshal(out.Bytes(), &entry)
+	require.Nil(t, err, err)
+	require.Equal(t, "GET", entry["method"])
+	require.Equal(t, "/ws1/data.json", entry["uri"])
+	require.Equal(t, float64(http.StatusCreated), entry["status"])
+	require.Equal(t, float64(5), entry["bytes"])
+	require.Equal(t, "ws1", entry["workspace"])
+	require.Equal(t, true, entry["exec"])
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	f, err := OpenRotatingFile(path, 10, 2)
+	require.Nil(t, err, err)
+	defer f.Close()
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err = f.Write([]byte(line))
+		require.Nil(t, err, err)
+	}
+
+	for path, data := range map[string]string{
+		path:        "dddddddd\n",
+		path + ".1": "cccccccc\n",
+		path + ".2": "bbbbbbbb\n",
+	} {
+		content, err := os.ReadFile(path)
+		require.Nil(t, err, err)
+		require.Equal(t, data, string(content))
+	}
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
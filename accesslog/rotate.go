@@ -0,0 +1,101 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a writer to a file that is rotated when it reaches the
+// maximum size, the rotated files are renamed with a numeric suffix
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	file *os.File
+	size int64
+	mtx  sync.Mutex
+}
+
+// OpenRotatingFile opens the file for appending, if max size is
+// zero or less then the file is never rotated
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write writes the data to the file, rotating the file first if
+// the data would cause the file to exceed the maximum size
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if f.MaxBackups > 0 {
+		for idx := f.MaxBackups - 1; idx > 0; idx-- {
+			err = os.Rename(backupPath(f.Path, idx), backupPath(f.Path, idx+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(f.Path, backupPath(f.Path, 1))
+	} else {
+		err = os.Remove(f.Path)
+	}
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.file.Close()
+}
+
+func backupPath(path string, idx int) string {
+	return fmt.Sprintf("%s.%d", path, idx)
+}
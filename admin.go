@@ -0,0 +1,151 @@
+package makeshiftd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/context"
+	"github.com/makeshiftd/makeshiftd/loggers"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// AdminSlug is the first path segment of the admin API
+const AdminSlug = "_admin"
+
+type adminListenerKey struct{}
+
+// withAdminListener marks the context of a request received by
+// a listener that explicitly lists the admin slug
+func withAdminListener(ctx context.C) context.C {
+	return context.WithValue(ctx, adminListenerKey{}, true)
+}
+
+// authorizeAdmin returns true if the request may use the admin API, the
+// request must be received by a listener that explicitly lists the admin
+// slug or the principal must be one of the configured admin principals
+// or in one of the admin groups, any authenticated principal is allowed
+// if neither are configured
+func (m *Makeshiftd) authorizeAdmin(req *http.Request) bool {
+	if listener, _ := req.Context().Value(adminListenerKey{}).(bool); listener {
+		return true
+	}
+	principal := auth.Ctx(req.Context())
+	if principal.IsAnonymous() {
+		return false
+	}
+	principals := m.config.GetStringSlice("admin.principals")
+	groups := m.config.GetStringSlice("admin.groups")
+	if len(principals) == 0 && len(groups) == 0 {
+		return true
+	}
+	for _, name := range principals {
+		if name == principal.Name {
+			return true
+		}
+	}
+	for _, group := range groups {
+		if principal.InGroup(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeAdmin serves the admin API, the request path is relative to the admin slug
+func (m *Makeshiftd) ServeAdmin(res http.ResponseWriter, req *http.Request) {
+	if !m.authorizeAdmin(req) {
+		log := log.Ctx(req.Context())
+		log.Debug().Msgf("Admin access denied: %s", auth.Ctx(req.Context()).Name)
+		m.ServeError(http.StatusForbidden, res, req)
+		return
+	}
+	segment, _ := urlpath.PopLeft(req.URL.Path)
+	switch segment {
+	case "loglevels":
+		m.serveLogLevels(res, req)
+	default:
+		m.ServeError(http.StatusNotFound, res, req)
+	}
+}
+
+type logLevels struct {
+	Default string            `json:"default,omitempty"`
+	Levels  map[string]string `json:"levels,omitempty"`
+}
+
+// serveLogLevels gets or sets the levels of the named loggers, setting
+// the level of a logger to 'default' resets it to the default level
+func (m *Makeshiftd) serveLogLevels(res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	switch req.Method {
+	case "GET", "HEAD":
+	case "PUT", "POST", "PATCH":
+		update := logLevels{}
+		err := json.NewDecoder(req.Body).Decode(&update)
+		if err != nil {
+			m.ServeError(http.StatusBadRequest, res, req)
+			return
+		}
+		err = setLogLevels(update)
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			res.Write([]byte(err.Error()))
+			return
+		}
+		log.Info().Msgf("Log levels updated: default: %s, levels: %v", update.Default, update.Levels)
+	default:
+		res.Header().Set("Allow", "GET, HEAD, PUT, POST, PATCH")
+		m.ServeError(http.StatusMethodNotAllowed, res, req)
+		return
+	}
+
+	levels := logLevels{
+		Default: loggers.DefaultLevel().String(),
+		Levels:  map[string]string{},
+	}
+	for name, level := range loggers.Levels() {
+		levels.Levels[name] = level.String()
+	}
+	data, err := json.Marshal(levels)
+	if err != nil {
+		m.ServeError(err, res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		res.Write(data)
+	}
+}
+
+func setLogLevels(update logLevels) error {
+	// Validate all the levels before any are set
+	for name, level := range update.Levels {
+		if strings.EqualFold(level, "default") {
+			continue
+		}
+		if _, err := loggers.ParseLevel(strings.ToLower(level)); err != nil {
+			return fmt.Errorf("Log level invalid: %s: %s", name, level)
+		}
+	}
+	if update.Default != "" {
+		level, err := loggers.ParseLevel(strings.ToLower(update.Default))
+		if err != nil {
+			return fmt.Errorf("Log level invalid: default: %s", update.Default)
+		}
+		loggers.SetDefaultLevel(level)
+	}
+	for name, level := range update.Levels {
+		if strings.EqualFold(level, "default") {
+			loggers.ResetLevel(name)
+			continue
+		}
+		l, _ := loggers.ParseLevel(strings.ToLower(level))
+		loggers.SetLevel(name, l)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package makeshiftd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+)
+
+func TestAdmin(t *testing.T) {
+	m := newTestMakeshiftd(t)
+	m.config.Set("admin.enabled", true)
+
+	table := []struct {
+		Name       string
+		Principals []string
+		Groups     []string
+		Principal  auth.Principal
+		Restrict   []string
+		Code       int
+	}{
+		{Name: "Anonymous", Code: http.StatusForbidden},
+		{Name: "Authenticated", Principal: auth.Principal{Name: "ann"}, Code: http.StatusOK},
+		{Name: "Principal", Principals: []string{"ann"}, Principal: auth.Principal{Name: "ann"}, Code: http.StatusOK},
+		{Name: "NotPrincipal", Principals: []string{"ann"}, Principal: auth.Principal{Name: "bob"}, Code: http.StatusForbidden},
+		{Name: "Group", Groups: []string{"ops"}, Principal: auth.Principal{Name: "bob", Groups: []string{"ops"}}, Code: http.StatusOK},
+		{Name: "NotGroup", Groups: []string{"ops"}, Principal: auth.Principal{Name: "bob"}, Code: http.StatusForbidden},
+		{Name: "Listener", Restrict: []string{AdminSlug}, Code: http.StatusOK},
+		{Name: "NotListener", Restrict: []string{"ws1"}, Code: http.StatusNotFound},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			m.config.Set("admin.principals", row.Principals)
+			m.config.Set("admin.groups", row.Groups)
+			req := httptest.NewRequest("GET", "/"+AdminSlug+"/loglevels", nil)
+			if row.Principal.Name != "" {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), row.Principal))
+			}
+			res := httptest.NewRecorder()
+			m.Restrict(row.Restrict).ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code, res.Body.String())
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+)
+
+// Anonymous is the name of the principal for unauthenticated requests
+const Anonymous = "anonymous"
+
+// Principal is the identity on whose behalf a request is made
+type Principal struct {
+	Name   string
+	Groups []string
+}
+
+// IsAnonymous returns true if the principal has not been authenticated
+func (p Principal) IsAnonymous() bool {
+	return p.Name == "" || p.Name == Anonymous
+}
+
+// InGroup returns true if the principal is a member of the named group
+func (p Principal) InGroup(group string) bool {
+	for _, g := range p.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context associated with the principal
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// Ctx returns the principal associated with the context. If no principal
+// is associated, the anonymous principal is returned.
+func Ctx(ctx context.Context) Principal {
+	if p, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return p
+	}
+	return Principal{Name: Anonymous}
+}
@@ -0,0 +1,289 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File names of the local certificate authority
+const (
+	CACertFileName = "ca.crt"
+	CAKeyFileName  = "ca.key"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+	certRenewal  = 30 * 24 * time.Hour
+)
+
+// Authority is a local certificate authority that issues certificates
+// for the configured host names on demand. The authority and the issued
+// certificates are stored in a directory, no network access is required.
+type Authority struct {
+	Dir   string
+	Hosts []string
+
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+
+	certs    map[string]*tls.Certificate
+	certsMtx sync.Mutex
+}
+
+// NewAuthority loads the certificate authority from the directory,
+// the authority is created if it does not already exist. Host names
+// may include wildcards, for example '*.localhost'.
+func NewAuthority(dir string, hosts []string) (*Authority, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+
+	a := &Authority{
+		Dir:   dir,
+		Hosts: hosts,
+		certs: map[string]*tls.Certificate{},
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	caCertFile := filepath.Join(dir, CACertFileName)
+	caKeyFile := filepath.Join(dir, CAKeyFileName)
+
+	cert, err := tls.LoadX509KeyPair(caCertFile, caKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		cert, err = a.createCA(caCertFile, caKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.caCert, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Certificate authority key is not a signer")
+	}
+	a.caKey = signer
+	return a, nil
+}
+
+// CACertFile returns the path of the certificate authority certificate,
+// this file must be installed in browsers to trust the issued certificates
+func (a *Authority) CACertFile() string {
+	return filepath.Join(a.Dir, CACertFileName)
+}
+
+// GetCertificate returns a certificate for the server name requested by the
+// client, it is intended for use as the GetCertificate function of tls.Config
+func (a *Authority) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		// Clients do not send the server name when connecting by IP address
+		name = a.defaultName(hello)
+	}
+	if !a.allowed(name) {
+		return nil, fmt.Errorf("Certificate not allowed for host: %s", name)
+	}
+
+	a.certsMtx.Lock()
+	defer a.certsMtx.Unlock()
+
+	cert := a.certs[name]
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > certRenewal {
+		return cert, nil
+	}
+
+	cert, err := a.loadCert(name)
+	if err != nil {
+		return nil, err
+	}
+	a.certs[name] = cert
+	return cert, nil
+}
+
+// defaultName returns the name of the certificate for clients that do not send
+// the server name, the local IP address of the connection if it is allowed
+func (a *Authority) defaultName(hello *tls.ClientHelloInfo) string {
+	if hello.Conn != nil {
+		host, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String())
+		if ip := net.ParseIP(host); err == nil && ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			if name := ip.String(); a.allowed(name) {
+				return name
+			}
+		}
+	}
+	return a.Hosts[0]
+}
+
+func (a *Authority) allowed(name string) bool {
+	for _, host := range a.Hosts {
+		if matchHost(strings.ToLower(host), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost returns true if the host name matches the pattern, the
+// wildcards of the pattern match within a single label of the name
+func matchHost(pattern, name string) bool {
+	patternLabels := strings.Split(pattern, ".")
+	nameLabels := strings.Split(name, ".")
+	if len(patternLabels) != len(nameLabels) {
+		return false
+	}
+	for idx, label := range patternLabels {
+		if matched, _ := path.Match(label, nameLabels[idx]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *Authority) loadCert(name string) (*tls.Certificate, error) {
+	fileName := strings.NewReplacer("*", "_", ":", "_").Replace(name)
+	certFile := filepath.Join(a.Dir, fileName+".crt")
+	keyFile := filepath.Join(a.Dir, fileName+".key")
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Until(cert.Leaf.NotAfter) > certRenewal {
+			return &cert, nil
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Msgf("Certificate invalid, issuing new certificate: %s", certFile)
+	}
+
+	cert, err = a.issueCert(name, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Certificate issued: %s", certFile)
+	return &cert, nil
+}
+
+func (a *Authority) createCA(certFile, keyFile string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template, err := newTemplate(caValidity)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.Subject = pkix.Name{
+		Organization: []string{"Makeshiftd"},
+		CommonName:   "Makeshiftd Local Development CA",
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	err = writeKeyPair(certFile, keyFile, der, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Info().Msgf("Certificate authority created: %s", certFile)
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+func (a *Authority) issueCert(name, certFile, keyFile string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template, err := newTemplate(certValidity)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.Subject = pkix.Name{
+		Organization: []string{"Makeshiftd"},
+		CommonName:   name,
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.caCert, key.Public(), a.caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	err = writeKeyPair(certFile, keyFile, der, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
+}
+
+func newTemplate(validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func writeKeyPair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	err = os.WriteFile(keyFile, keyPem, 0600)
+	if err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return os.WriteFile(certFile, certPem, 0644)
+}
@@ -0,0 +1,103 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthority(t *testing.T) {
+	dir := t.TempDir()
+
+	a, err := NewAuthority(dir, []string{"localhost", "*.test"})
+	require.Nil(t, err, err)
+
+	caPem, err := os.ReadFile(a.CACertFile())
+	require.Nil(t, err, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPem))
+
+	table := []struct {
+		ServerName string
+		Allowed    bool
+	}{
+		{ServerName: "localhost", Allowed: true},
+		{ServerName: "app.test", Allowed: true},
+		{ServerName: "a.b.test", Allowed: false},
+		{ServerName: "test", Allowed: false},
+		{ServerName: "", Allowed: true},
+		{ServerName: "example.com", Allowed: false},
+	}
+
+	for _, row := range table {
+		t.Run("ServerName:"+row.ServerName, func(t *testing.T) {
+			cert, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: row.ServerName})
+			if !row.Allowed {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err, err)
+
+			name := row.ServerName
+			if name == "" {
+				name = "localhost"
+			}
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+			require.Nil(t, err, err)
+		})
+	}
+
+	// The authority and issued certificates are reused from the directory
+	b, err := NewAuthority(dir, []string{"localhost"})
+	require.Nil(t, err, err)
+	require.Equal(t, a.caCert.Raw, b.caCert.Raw)
+
+	certA, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+	require.Nil(t, err, err)
+	certB, err := b.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+	require.Nil(t, err, err)
+	require.Equal(t, certA.Certificate, certB.Certificate)
+}
+
+// localConn is a connection with the given local address
+type localConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *localConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func TestAuthorityNoServerName(t *testing.T) {
+	a, err := NewAuthority(t.TempDir(), nil)
+	require.Nil(t, err, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(a.caCert)
+
+	table := []struct {
+		LocalAddr string
+		Name      string
+	}{
+		{LocalAddr: "127.0.0.1:8443", Name: "127.0.0.1"},
+		{LocalAddr: "[::1]:8443", Name: "::1"},
+		{LocalAddr: "[::ffff:127.0.0.1]:8443", Name: "127.0.0.1"},
+		{LocalAddr: "192.0.2.1:8443", Name: "localhost"},
+	}
+
+	for _, row := range table {
+		t.Run(row.LocalAddr, func(t *testing.T) {
+			addr, err := net.ResolveTCPAddr("tcp", row.LocalAddr)
+			require.Nil(t, err, err)
+			cert, err := a.GetCertificate(&tls.ClientHelloInfo{Conn: &localConn{addr: addr}})
+			require.Nil(t, err, err)
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: row.Name, Roots: roots})
+			require.Nil(t, err, err)
+		})
+	}
+}
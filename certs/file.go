@@ -0,0 +1,93 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/makeshiftd/makeshiftd/loggers"
+)
+
+var log = loggers.NewLazyLoggerPkg("certs")
+
+// ReloadInterval is the minimum interval between checks for changes
+// to the certificate and key files
+var ReloadInterval = 5 * time.Second
+
+// FileSource provides a certificate loaded from PEM encoded certificate
+// and key files, the files are reloaded when they are modified
+type FileSource struct {
+	CertFile string
+	KeyFile  string
+
+	cert      *tls.Certificate
+	certTime  time.Time
+	keyTime   time.Time
+	checked   time.Time
+	reloadMtx sync.Mutex
+}
+
+// NewFileSource creates a new file certificate source and loads the certificate
+func NewFileSource(certFile, keyFile string) (*FileSource, error) {
+	s := &FileSource{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+	_, err := s.reload(true)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetCertificate returns the certificate, reloading it if the files have changed,
+// it is intended for use as the GetCertificate function of tls.Config
+func (s *FileSource) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.reload(false)
+}
+
+func (s *FileSource) reload(force bool) (*tls.Certificate, error) {
+	s.reloadMtx.Lock()
+	defer s.reloadMtx.Unlock()
+
+	now := time.Now()
+	if !force && now.Sub(s.checked) < ReloadInterval {
+		return s.cert, nil
+	}
+	s.checked = now
+
+	certInfo, err := os.Stat(s.CertFile)
+	if err != nil {
+		return s.fallback(err)
+	}
+	keyInfo, err := os.Stat(s.KeyFile)
+	if err != nil {
+		return s.fallback(err)
+	}
+	if !force && certInfo.ModTime().Equal(s.certTime) && keyInfo.ModTime().Equal(s.keyTime) {
+		return s.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return s.fallback(err)
+	}
+	log.Info().Msgf("Certificate loaded: %s", s.CertFile)
+
+	s.cert = &cert
+	s.certTime = certInfo.ModTime()
+	s.keyTime = keyInfo.ModTime()
+	return s.cert, nil
+}
+
+// fallback continues to use the previous certificate if the reload fails,
+// for example while the files are being replaced
+func (s *FileSource) fallback(err error) (*tls.Certificate, error) {
+	if s.cert == nil {
+		return nil, fmt.Errorf("Certificate not loaded: %w", err)
+	}
+	log.Warn().Err(err).Msgf("Certificate reload failed: %s", s.CertFile)
+	return s.cert, nil
+}
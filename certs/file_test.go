@@ -0,0 +1,53 @@
+package certs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+
+	a, err := NewAuthority(filepath.Join(dir, "ca"), []string{"localhost", "other.test"})
+	require.Nil(t, err, err)
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	_, err = a.issueCert("localhost", certFile, keyFile)
+	require.Nil(t, err, err)
+
+	s, err := NewFileSource(certFile, keyFile)
+	require.Nil(t, err, err)
+	cert, err := s.GetCertificate(nil)
+	require.Nil(t, err, err)
+	first := cert.Certificate[0]
+
+	reloadInterval := ReloadInterval
+	ReloadInterval = 0
+	defer func() { ReloadInterval = reloadInterval }()
+
+	// The certificate is reloaded when the files are modified
+	_, err = a.issueCert("other.test", certFile, keyFile)
+	require.Nil(t, err, err)
+	modified := time.Now().Add(time.Minute)
+	require.Nil(t, os.Chtimes(certFile, modified, modified))
+	require.Nil(t, os.Chtimes(keyFile, modified, modified))
+
+	cert, err = s.GetCertificate(nil)
+	require.Nil(t, err, err)
+	require.NotEqual(t, first, cert.Certificate[0])
+	second := cert.Certificate[0]
+
+	// The previous certificate is used while the files are being replaced
+	require.Nil(t, os.Remove(keyFile))
+	cert, err = s.GetCertificate(nil)
+	require.Nil(t, err, err)
+	require.Equal(t, second, cert.Certificate[0])
+
+	_, err = NewFileSource(certFile, keyFile)
+	require.NotNil(t, err)
+}
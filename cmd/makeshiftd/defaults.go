@@ -5,7 +5,10 @@ import "github.com/spf13/viper"
 // Set the application defaults
 func init() {
 	viper.SetDefault("server", map[string]interface{}{
-		"port": 8080,
-		"host": "",
+		"port":       8080,
+		"host":       "",
+		"h2c":        false,
+		"http3":      false,
+		"drainDelay": "0s",
 	})
 }
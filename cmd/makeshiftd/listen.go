@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Listener networks
+const (
+	NetworkTCP     = "tcp"
+	NetworkUnix    = "unix"
+	NetworkSystemd = "systemd"
+)
+
+// listenerConfig is the configuration of a server listener
+type listenerConfig struct {
+	Network    string   `mapstructure:"network"`
+	Address    string   `mapstructure:"address"`
+	Path       string   `mapstructure:"path"`
+	Mode       string   `mapstructure:"mode"`
+	Owner      string   `mapstructure:"owner"`
+	Group      string   `mapstructure:"group"`
+	Name       string   `mapstructure:"name"`
+	TLS        *bool    `mapstructure:"tls"`
+	Workspaces []string `mapstructure:"workspaces"`
+}
+
+// listenerConfigs returns the configured listeners, if no listeners are
+// configured then a single TCP listener is created from the host and port
+func listenerConfigs(c *viper.Viper) ([]listenerConfig, error) {
+	var configs []listenerConfig
+	err := c.UnmarshalKey("listeners", &configs)
+	if err != nil {
+		return nil, fmt.Errorf("Server listeners configuration invalid: %w", err)
+	}
+	if len(configs) == 0 {
+		configs = append(configs, listenerConfig{
+			Network: NetworkTCP,
+			Address: fmt.Sprintf("%s:%s", c.GetString("host"), c.GetString("port")),
+		})
+	}
+	return configs, nil
+}
+
+// String returns a description of the listener for logging
+func (lc *listenerConfig) String() string {
+	switch lc.Network {
+	case NetworkUnix:
+		return "unix:" + lc.Path
+	case NetworkSystemd:
+		return "systemd:" + lc.Name
+	}
+	return lc.Address
+}
+
+// useTLS returns true if the listener serves TLS, by default TLS is used
+// for TCP and systemd listeners when TLS is configured for the server
+func (lc *listenerConfig) useTLS(tlsConfig *tls.Config) bool {
+	if tlsConfig == nil {
+		return false
+	}
+	if lc.TLS != nil {
+		return *lc.TLS
+	}
+	return lc.Network != NetworkUnix
+}
+
+// listen opens the listeners for the configuration, systemd
+// socket activation can provide more than one listener
+func (lc *listenerConfig) listen() ([]net.Listener, error) {
+	switch lc.Network {
+	case "", NetworkTCP:
+		l, err := net.Listen("tcp", lc.Address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+
+	case NetworkUnix:
+		l, err := lc.listenUnix()
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+
+	case NetworkSystemd:
+		return systemdListeners(lc.Name)
+	}
+	return nil, fmt.Errorf("Listener network not supported: %s", lc.Network)
+}
+
+func (lc *listenerConfig) listenUnix() (net.Listener, error) {
+	if lc.Path == "" {
+		return nil, fmt.Errorf("Listener unix socket path required")
+	}
+
+	// Remove a stale socket remaining from a previous process
+	if info, err := os.Stat(lc.Path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(lc.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: lc.Path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The socket is removed when the listener is closed
+	l.SetUnlinkOnClose(true)
+
+	err = lc.chmodUnix()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (lc *listenerConfig) chmodUnix() error {
+	if lc.Mode != "" {
+		mode, err := strconv.ParseUint(lc.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("Listener unix socket mode invalid: %s", lc.Mode)
+		}
+		err = os.Chmod(lc.Path, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+	}
+
+	if lc.Owner == "" && lc.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if lc.Owner != "" {
+		u, err := user.Lookup(lc.Owner)
+		if err != nil {
+			return err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if lc.Group != "" {
+		g, err := user.LookupGroup(lc.Group)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return os.Chown(lc.Path, uid, gid)
+}
+
+// The file descriptors passed by systemd socket activation start at 3
+const systemdListenFdsStart = 3
+
+type systemdFile struct {
+	name string
+	file *os.File
+}
+
+var systemdFiles []systemdFile
+var systemdFilesOnce sync.Once
+
+// systemdListenFiles returns the files passed by systemd socket activation
+// from the environment, the file descriptors start at the given descriptor
+func systemdListenFiles(start int) []systemdFile {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil
+	}
+	var files []systemdFile
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for idx := 0; idx < nfds; idx++ {
+		fd := start + idx
+		fdName := ""
+		if idx < len(names) {
+			fdName = names[idx]
+		}
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("systemd:%d", fd))
+		files = append(files, systemdFile{name: fdName, file: file})
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return files
+}
+
+// systemdListeners returns the listeners inherited from systemd socket
+// activation with the given name, if the name is empty all are returned
+func systemdListeners(name string) ([]net.Listener, error) {
+	systemdFilesOnce.Do(func() {
+		systemdFiles = systemdListenFiles(systemdListenFdsStart)
+	})
+
+	var listeners []net.Listener
+	for _, sf := range systemdFiles {
+		if name != "" && name != sf.name {
+			continue
+		}
+		l, err := net.FileListener(sf.file)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("Listener not provided by systemd: %s", name)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,108 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	current, err := user.Current()
+	require.Nil(t, err, err)
+	group, err := user.LookupGroupId(current.Gid)
+	require.Nil(t, err, err)
+
+	path := filepath.Join(t.TempDir(), "makeshiftd.sock")
+	lc := &listenerConfig{
+		Network: NetworkUnix,
+		Path:    path,
+		Mode:    "0600",
+		Owner:   current.Username,
+		Group:   group.Name,
+	}
+
+	// A stale socket remaining from a previous process is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.Nil(t, err, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, err := lc.listen()
+	require.Nil(t, err, err)
+	require.Len(t, listeners, 1)
+
+	info, err := os.Stat(path)
+	require.Nil(t, err, err)
+	require.NotZero(t, info.Mode()&os.ModeSocket)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	stat := info.Sys().(*syscall.Stat_t)
+	require.Equal(t, current.Uid, strconv.Itoa(int(stat.Uid)))
+	require.Equal(t, current.Gid, strconv.Itoa(int(stat.Gid)))
+
+	conn, err := net.Dial("unix", path)
+	require.Nil(t, err, err)
+	conn.Close()
+
+	// The socket is removed when the listener is closed
+	require.Nil(t, listeners[0].Close())
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	lc.Mode = "rw"
+	_, err = lc.listen()
+	require.NotNil(t, err)
+}
+
+func TestSystemdListeners(t *testing.T) {
+	// The sockets are passed at consecutive descriptors, as by systemd
+	const start = 100
+	for idx := 0; idx < 2; idx++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.Nil(t, err, err)
+		file, err := l.(*net.TCPListener).File()
+		require.Nil(t, err, err)
+		require.Nil(t, syscall.Dup2(int(file.Fd()), start+idx))
+		file.Close()
+		l.Close()
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_FDNAMES", "http:admin")
+	systemdFilesOnce.Do(func() {
+		systemdFiles = systemdListenFiles(start)
+	})
+	require.Len(t, systemdFiles, 2)
+	require.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	lc := &listenerConfig{Network: NetworkSystemd, Name: "admin"}
+	listeners, err := lc.listen()
+	require.Nil(t, err, err)
+	require.Len(t, listeners, 1)
+	defer listeners[0].Close()
+
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	require.Nil(t, err, err)
+	conn.Close()
+
+	lc.Name = ""
+	listeners, err = lc.listen()
+	require.Nil(t, err, err)
+	require.Len(t, listeners, 2)
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	lc.Name = "missing"
+	_, err = lc.listen()
+	require.NotNil(t, err)
+}
@@ -18,8 +18,11 @@ import (
 
 	wg "github.com/dxmaxwell/workgroup"
 	"github.com/makeshiftd/makeshiftd"
+	"github.com/makeshiftd/makeshiftd/accesslog"
 	"github.com/makeshiftd/makeshiftd/context"
 	"github.com/makeshiftd/makeshiftd/loggers"
+	"github.com/makeshiftd/makeshiftd/metrics"
+	"github.com/makeshiftd/makeshiftd/request"
 )
 
 var log = loggers.NewLazyLoggerPkg("main")
@@ -89,6 +92,34 @@ func main() {
 				}
 			}
 		},
+		func(ctx context.C) error {
+			log := log.With().Str("wk", "signal").Logger()
+
+			if logLevelUpSignal == nil || logLevelDownSignal == nil {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+
+			levelSignal := make(chan os.Signal, 1)
+			signal.Notify(levelSignal, logLevelUpSignal, logLevelDownSignal)
+			defer signal.Stop(levelSignal)
+
+			for {
+				select {
+				case s := <-levelSignal:
+					if s == logLevelUpSignal {
+						loggers.StepLevels(-1)
+					} else {
+						loggers.StepLevels(1)
+					}
+					log.Info().Msgf("Log level signal recieved: %s: default level: %s", s, loggers.DefaultLevel())
+
+				case <-ctx.Done():
+					log.Trace().Err(ctx.Err()).Msg("Worker context done")
+					return ctx.Err()
+				}
+			}
+		},
 		func(ctx context.C) error {
 			log := log.With().Str("wk", "main").Logger()
 			err := mainWithContexts(log.WithContext(mainCtx), shutdownCtx)
@@ -143,33 +174,95 @@ func mainWithContexts(mainCtx, shutdownCtx context.C) error {
 		log.Info().Msgf("Configuration file read: %s", configFile)
 	}
 
+	err = configureLogLevels(viper.Sub("log"))
+	if err != nil {
+		log.Err(err).Msg("Log configuration invalid")
+		return err
+	}
+
 	handler := makeshiftd.New(viper.GetViper())
+	err = handler.Check()
+	if err != nil {
+		log.Err(err).Msg("Configuration invalid")
+		return err
+	}
+	handler.WatchShutdown(mainCtx, shutdownCtx)
+
+	accessLog, err := accesslog.New(viper.Sub("accessLog"), configDir())
+	if err != nil {
+		log.Err(err).Msg("Access log configuration invalid")
+		return err
+	}
+
+	middleware := func(h http.Handler) http.Handler {
+		if viper.GetBool("metrics.enabled") {
+			h = metrics.Instrument(h)
+		}
+		return request.Identify(accessLog.Handler(h))
+	}
 
 	log.Info().Msg("Makeshiftd starting")
-	err = listenAndServe(mainCtx, shutdownCtx, handler, viper.Sub("server"))
+	err = listenAndServe(mainCtx, shutdownCtx, handler, middleware, viper.Sub("server"))
 	log.Info().Msg("Makshiftd stopped")
 	return err
 }
 
-func listenAndServe(serverCtx, shutdownCtx context.C, handler http.Handler, c *viper.Viper) error {
+// configureLogLevels sets the default level and the levels of the named loggers
+func configureLogLevels(c *viper.Viper) error {
+	if c == nil {
+		return nil
+	}
+	if c.IsSet("level") {
+		level, err := loggers.ParseLevel(strings.ToLower(c.GetString("level")))
+		if err != nil {
+			return err
+		}
+		loggers.SetDefaultLevel(level)
+	}
+	for name, value := range c.GetStringMapString("levels") {
+		level, err := loggers.ParseLevel(strings.ToLower(value))
+		if err != nil {
+			return fmt.Errorf("Log level invalid: %s: %w", name, err)
+		}
+		loggers.SetLevel(name, level)
+	}
+	return nil
+}
 
-	address := fmt.Sprintf("%s:%s", c.GetString("host"), c.GetString("port"))
+func listenAndServe(serverCtx, shutdownCtx context.C, handler *makeshiftd.Makeshiftd, middleware func(http.Handler) http.Handler, c *viper.Viper) error {
 
 	serveWorkers := make(chan wg.Worker)
 
-	server := &http.Server{
-		Addr: address,
-		Handler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+	servers, err := newListenServers(handler, c, func(handler http.Handler) http.Handler {
+		handler = middleware(handler)
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 			w := sync.WaitGroup{}
 			w.Add(1)
 			serveWorkers <- func(ctx context.C) error {
 				defer w.Done()
+				metrics.InFlight.Inc()
+				defer metrics.InFlight.Dec()
 				loggers.Ctx(ctx).Trace().Msg("HTTP server serve request")
-				handler.ServeHTTP(res, req)
+				// Request loggers are derived from the root logger, each package adds its 'pkg'
+				handler.ServeHTTP(res, req.WithContext(zlog.Logger.WithContext(req.Context())))
 				return nil
 			}
 			w.Wait()
-		}),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	metricsServer, err := newMetricsServer(viper.Sub("metrics"))
+	if err != nil {
+		for _, s := range servers {
+			s.Close()
+		}
+		return err
+	}
+	if metricsServer != nil {
+		servers = append(servers, metricsServer)
 	}
 
 	log := log.With().Str("wg", "serve").Logger()
@@ -184,9 +277,27 @@ func listenAndServe(serverCtx, shutdownCtx context.C, handler http.Handler, c *v
 
 			select {
 			case <-serverCtx.Done():
+				// Allow time for load balancers to observe that the server is not ready
+				if drainDelay := c.GetDuration("drainDelay"); drainDelay > 0 {
+					log.Info().Msgf("HTTP server draining: %s", drainDelay)
+					select {
+					case <-time.After(drainDelay):
+					case <-shutdownCtx.Done():
+					}
+				}
 				log.Info().Msg("HTTP server shutdown started")
-				err := server.Shutdown(shutdownCtx)
-				if !errors.Is(err, http.ErrServerClosed) {
+				handler.Shutdown()
+				err := wg.WorkFor(context.Background(), len(servers), nil, wg.CancelNeverFirstError(),
+					func(ctx context.C, idx int) error {
+						err := servers[idx].Shutdown(shutdownCtx)
+						if err != nil && !errors.Is(err, http.ErrServerClosed) {
+							log.Err(err).Msgf("HTTP server shutdown failed: %s", servers[idx].desc)
+							return err
+						}
+						return nil
+					},
+				)
+				if err != nil {
 					log.Err(err).Msg("HTTP server shutdown complete")
 					return err
 				}
@@ -198,19 +309,30 @@ func listenAndServe(serverCtx, shutdownCtx context.C, handler http.Handler, c *v
 				return ctx.Err()
 			}
 		},
-		func(ctx context.C) error {
-			log := log.With().Str("wk", "listen").Logger()
+		wg.GroupFor(len(servers), nil, wg.CancelOnFirstDone(),
+			func(ctx context.C, idx int) error {
+				log := log.With().Str("wk", "listen").Logger()
+				s := servers[idx]
 
-			log.Info().Msgf("HTTP server listening: %s", address)
-			err := server.ListenAndServe()
-			if !errors.Is(err, http.ErrServerClosed) {
-				log.Err(err).Msg("HTTP server listening stopped")
-				return err
-			}
+				if s.tls {
+					log.Info().Msgf("HTTPS server listening: %s", s.desc)
+				} else {
+					log.Info().Msgf("HTTP server listening: %s", s.desc)
+				}
+				err := s.Serve()
+				if !errors.Is(err, http.ErrServerClosed) {
+					log.Err(err).Msgf("HTTP server listening stopped: %s", s.desc)
+					// Stop the other servers so the listen group completes
+					for _, other := range servers {
+						other.Close()
+					}
+					return err
+				}
 
-			log.Info().Msg("HTTP server listening stopped")
-			return nil
-		},
+				log.Info().Msgf("HTTP server listening stopped: %s", s.desc)
+				return nil
+			},
+		),
 		func(ctx context.C) error {
 			log := log.With().Str("wk", "serve").Logger()
 			return wg.WorkChan(log.WithContext(ctx), nil, wg.CancelNeverFirstError(), serveWorkers)
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	wg "github.com/dxmaxwell/workgroup"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/spf13/viper"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/makeshiftd/makeshiftd"
+	"github.com/makeshiftd/makeshiftd/context"
+	"github.com/makeshiftd/makeshiftd/metrics"
+)
+
+// listenServer is an HTTP server for a single listener, when enabled
+// the server also serves HTTP/3 on a UDP socket with the same address
+type listenServer struct {
+	server   *http.Server
+	listener net.Listener
+	tls      bool
+	desc     string
+
+	h3     *http3.Server
+	h3conn net.PacketConn
+}
+
+// newListenServers creates a server for each of the configured listeners,
+// the wrap function is applied to the handler of each of the servers
+func newListenServers(handler *makeshiftd.Makeshiftd, c *viper.Viper, wrap func(http.Handler) http.Handler) ([]*listenServer, error) {
+
+	tlsConfig, err := newTLSConfig(c.Sub("tls"))
+	if err != nil {
+		log.Err(err).Msg("HTTP server TLS configuration invalid")
+		return nil, err
+	}
+
+	configs, err := listenerConfigs(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []*listenServer
+	closeServers := func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+	for idx := range configs {
+		lc := &configs[idx]
+		listeners, err := lc.listen()
+		if err != nil {
+			log.Err(err).Msgf("HTTP server listen failed: %s", lc)
+			closeServers()
+			return nil, err
+		}
+		for _, l := range listeners {
+			s := &listenServer{
+				listener: l,
+				tls:      lc.useTLS(tlsConfig),
+				desc:     l.Addr().Network() + ":" + l.Addr().String(),
+			}
+			servers = append(servers, s)
+
+			h := wrap(handler.Restrict(lc.Workspaces))
+
+			if s.tls && c.GetBool("http3") && l.Addr().Network() == "tcp" {
+				s.h3conn, err = net.ListenPacket("udp", l.Addr().String())
+				if err != nil {
+					log.Err(err).Msgf("HTTP/3 server listen failed: %s", s.desc)
+					closeServers()
+					return nil, err
+				}
+				s.h3 = &http3.Server{
+					Handler:   h,
+					TLSConfig: tlsConfig,
+				}
+				h = altSvcHandler(s.h3, h)
+			}
+
+			if !s.tls && c.GetBool("h2c") {
+				h = h2c.NewHandler(h, &http2.Server{})
+			}
+
+			s.server = &http.Server{
+				Handler:   h,
+				TLSConfig: tlsConfig,
+			}
+		}
+	}
+	return servers, nil
+}
+
+// newMetricsServer creates a server for the metrics if a separate listener
+// is configured, so that the metrics are not served with the workspaces
+func newMetricsServer(c *viper.Viper) (*listenServer, error) {
+	if c == nil || !c.GetBool("enabled") || c.GetString("listen") == "" {
+		return nil, nil
+	}
+	l, err := net.Listen("tcp", c.GetString("listen"))
+	if err != nil {
+		log.Err(err).Msgf("Metrics server listen failed: %s", c.GetString("listen"))
+		return nil, err
+	}
+	return &listenServer{
+		server:   &http.Server{Handler: metrics.Handler()},
+		listener: l,
+		desc:     l.Addr().Network() + ":" + l.Addr().String(),
+	}, nil
+}
+
+// altSvcHandler advertises the HTTP/3 server in the response headers
+func altSvcHandler(h3 *http3.Server, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor < 3 {
+			h3.SetQuicHeaders(res.Header())
+		}
+		handler.ServeHTTP(res, req)
+	})
+}
+
+// Serve accepts connections until the server is shutdown,
+// after which http.ErrServerClosed is returned
+func (s *listenServer) Serve() error {
+	if s.h3 == nil {
+		return s.serve()
+	}
+	return wg.Work(context.Background(), nil, wg.CancelOnFirstDone(),
+		func(ctx context.C) error {
+			defer s.h3.Close()
+			return s.serve()
+		},
+		func(ctx context.C) error {
+			defer s.h3conn.Close()
+			err := s.h3.Serve(s.h3conn)
+			if !errors.Is(err, http.ErrServerClosed) {
+				log.Err(err).Msgf("HTTP/3 server listening stopped: %s", s.desc)
+				s.server.Close()
+			}
+			return err
+		},
+	)
+}
+
+func (s *listenServer) serve() error {
+	if s.tls {
+		return s.server.ServeTLS(s.listener, "", "")
+	}
+	return s.server.Serve(s.listener)
+}
+
+// Shutdown gracefully shuts down the server, HTTP/3 connections
+// are closed immediately as graceful shutdown is not supported
+func (s *listenServer) Shutdown(ctx context.C) error {
+	if s.h3 != nil {
+		s.h3.Close()
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// Close immediately closes the server and the listeners
+func (s *listenServer) Close() error {
+	if s.h3 != nil {
+		s.h3.Close()
+		s.h3conn.Close()
+	}
+	if s.server != nil {
+		s.server.Close()
+	}
+	return s.listener.Close()
+}
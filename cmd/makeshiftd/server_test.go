@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"github.com/makeshiftd/makeshiftd"
+	"github.com/makeshiftd/makeshiftd/context"
+)
+
+func newTestHandler(t *testing.T) *makeshiftd.Makeshiftd {
+	config := viper.New()
+	config.SetConfigFile(filepath.Join("..", "..", "testdata", "makeshiftd.json"))
+	err := config.ReadInConfig()
+	require.Nil(t, err, err)
+	return makeshiftd.New(config)
+}
+
+func startTestServers(t *testing.T, c *viper.Viper) []*listenServer {
+	handler := newTestHandler(t)
+	servers, err := newListenServers(handler, c, func(h http.Handler) http.Handler { return h })
+	require.Nil(t, err, err)
+	for _, s := range servers {
+		go s.Serve()
+	}
+	t.Cleanup(func() {
+		for _, s := range servers {
+			s.Shutdown(context.Background())
+		}
+	})
+	return servers
+}
+
+func TestServerProtocols(t *testing.T) {
+	dir := t.TempDir()
+
+	c := viper.New()
+	c.Set("h2c", true)
+	c.Set("http3", true)
+	c.Set("tls.auto.enabled", true)
+	c.Set("tls.auto.dir", dir)
+	c.Set("listeners", []interface{}{
+		map[string]interface{}{"network": "tcp", "address": "127.0.0.1:0", "tls": false},
+		map[string]interface{}{"network": "tcp", "address": "127.0.0.1:0"},
+	})
+
+	servers := startTestServers(t, c)
+	require.Len(t, servers, 2)
+	plainAddr := servers[0].listener.Addr().String()
+	tlsAddr := servers[1].listener.Addr().String()
+
+	caPem, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	require.Nil(t, err, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPem))
+	tlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+
+	table := []struct {
+		Name   string
+		URL    string
+		Client *http.Client
+		Proto  string
+	}{
+		{
+			Name:   "HTTP/1.1",
+			URL:    "http://" + plainAddr,
+			Client: &http.Client{},
+			Proto:  "HTTP/1.1",
+		},
+		{
+			Name: "H2C",
+			URL:  "http://" + plainAddr,
+			Client: &http.Client{Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+					return net.Dial(network, addr)
+				},
+			}},
+			Proto: "HTTP/2.0",
+		},
+		{
+			Name: "HTTPS/1.1",
+			URL:  "https://" + tlsAddr,
+			Client: &http.Client{Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			}},
+			Proto: "HTTP/1.1",
+		},
+		{
+			Name: "HTTPS/2",
+			URL:  "https://" + tlsAddr,
+			Client: &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   tlsConfig,
+				ForceAttemptHTTP2: true,
+			}},
+			Proto: "HTTP/2.0",
+		},
+		{
+			Name: "HTTP/3",
+			URL:  "https://" + tlsAddr,
+			Client: &http.Client{Transport: &http3.RoundTripper{
+				TLSClientConfig: tlsConfig,
+			}},
+			Proto: "HTTP/3.0",
+		},
+	}
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "workspace1", "data.json"))
+	require.Nil(t, err, err)
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			res, err := row.Client.Get(row.URL + "/ws1/data.json")
+			require.Nil(t, err, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, row.Proto, res.Proto)
+
+			body, err := io.ReadAll(res.Body)
+			require.Nil(t, err, err)
+			require.Equal(t, data, body)
+
+			if row.URL == "https://"+tlsAddr && res.ProtoMajor < 3 {
+				require.NotEmpty(t, res.Header.Get("Alt-Svc"))
+			}
+		})
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	s, err := newMetricsServer(nil)
+	require.Nil(t, err, err)
+	require.Nil(t, s)
+
+	c := viper.New()
+	c.Set("enabled", true)
+	c.Set("listen", "127.0.0.1:0")
+	s, err = newMetricsServer(c)
+	require.Nil(t, err, err)
+	require.NotNil(t, s)
+	go s.Serve()
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	res, err := http.Get("http://" + s.listener.Addr().String() + "/metrics")
+	require.Nil(t, err, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.Nil(t, err, err)
+	require.Contains(t, string(body), "go_goroutines")
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals to step the log levels up (more verbose) or down (less verbose)
+var (
+	logLevelUpSignal   os.Signal = syscall.SIGUSR1
+	logLevelDownSignal os.Signal = syscall.SIGUSR2
+)
@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+// Signals to step the log levels are not supported on Windows
+var (
+	logLevelUpSignal   os.Signal
+	logLevelDownSignal os.Signal
+)
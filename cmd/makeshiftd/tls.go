@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+
+	"github.com/makeshiftd/makeshiftd/certs"
+)
+
+// configDir returns the directory of the configuration file used, or the
+// user configuration directory if no configuration file has been read
+func configDir() string {
+	configFile := viper.ConfigFileUsed()
+	if configFile != "" {
+		return filepath.Dir(configFile)
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(dir, "makeshiftd")
+}
+
+// configPath resolves a path relative to the configuration directory
+func configPath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(configDir(), path)
+}
+
+// newTLSConfig creates the TLS configuration from the server configuration,
+// if TLS is not configured then nil is returned
+func newTLSConfig(c *viper.Viper) (*tls.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	if c.GetBool("auto.enabled") {
+		dir := c.GetString("auto.dir")
+		if dir == "" {
+			dir = "tls"
+		}
+		authority, err := certs.NewAuthority(configPath(dir), c.GetStringSlice("auto.hosts"))
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Msgf("TLS certificate authority: %s", authority.CACertFile())
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: authority.GetCertificate,
+		}, nil
+	}
+
+	certFile := c.GetString("cert")
+	keyFile := c.GetString("key")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	source, err := certs.NewFileSource(configPath(certFile), configPath(keyFile))
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: source.GetCertificate,
+	}, nil
+}
@@ -0,0 +1,158 @@
+package cors
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultMethods are the methods allowed if none are configured
+var DefaultMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// Policy is a cross-origin resource sharing policy. Allowed origins may
+// contain wildcards, for example 'https://*.example.com', or be '*' to allow
+// all origins. Allowed headers may be '*' to allow all requested headers.
+type Policy struct {
+	AllowedOrigins   []string `mapstructure:"allowedOrigins"`
+	AllowedMethods   []string `mapstructure:"allowedMethods"`
+	AllowedHeaders   []string `mapstructure:"allowedHeaders"`
+	ExposedHeaders   []string `mapstructure:"exposedHeaders"`
+	AllowCredentials bool     `mapstructure:"allowCredentials"`
+	MaxAge           int      `mapstructure:"maxAge"`
+}
+
+// IsPreflight returns true if the request is a CORS preflight request
+func IsPreflight(req *http.Request) bool {
+	return req.Method == "OPTIONS" &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Handle adds the CORS headers to the response, if the request is a preflight
+// request then the response is written and true is returned, otherwise
+// the request should continue to be handled
+func (p *Policy) Handle(res http.ResponseWriter, req *http.Request) bool {
+	header := res.Header()
+	origin := req.Header.Get("Origin")
+	preflight := IsPreflight(req)
+
+	if preflight {
+		header.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	} else {
+		header.Add("Vary", "Origin")
+	}
+
+	if origin == "" {
+		return false
+	}
+
+	if !p.allowOrigin(origin) {
+		if preflight {
+			res.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+
+	if p.allowAnyOrigin() && !p.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if len(p.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	method := req.Header.Get("Access-Control-Request-Method")
+	if !p.allowMethod(method) {
+		res.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	methods := p.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+	if requested := req.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		if !p.allowHeaders(requested) {
+			res.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+
+	if p.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+	}
+	res.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// AllowsOrigin returns true if the origin is allowed by the policy
+func (p *Policy) AllowsOrigin(origin string) bool {
+	return p.allowOrigin(origin)
+}
+
+func (p *Policy) allowAnyOrigin() bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) allowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+		if matched, _ := path.Match(strings.ToLower(o), origin); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) allowMethod(method string) bool {
+	methods := p.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) allowHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		allowed := false
+		for _, a := range p.AllowedHeaders {
+			if a == "*" || strings.EqualFold(a, h) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
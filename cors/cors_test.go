@@ -0,0 +1,74 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle(t *testing.T) {
+	policy := &Policy{
+		AllowedOrigins:   []string{"https://*.example.com", "http://localhost:3000"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"Location"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+
+	table := []struct {
+		Name          string
+		Method        string
+		Origin        string
+		RequestMethod string
+		RequestHeader string
+		Handled       bool
+		Code          int
+		AllowOrigin   string
+	}{
+		{Name: "NoOrigin", Method: "GET", Handled: false},
+		{Name: "Simple", Method: "GET", Origin: "https://app.example.com", AllowOrigin: "https://app.example.com"},
+		{Name: "Disallowed", Method: "GET", Origin: "https://example.org", AllowOrigin: ""},
+		{Name: "Preflight", Method: "OPTIONS", Origin: "http://localhost:3000", RequestMethod: "PUT", RequestHeader: "content-type",
+			Handled: true, Code: http.StatusNoContent, AllowOrigin: "http://localhost:3000"},
+		{Name: "PreflightMethod", Method: "OPTIONS", Origin: "http://localhost:3000", RequestMethod: "DELETE",
+			Handled: true, Code: http.StatusForbidden, AllowOrigin: "http://localhost:3000"},
+		{Name: "PreflightHeader", Method: "OPTIONS", Origin: "http://localhost:3000", RequestMethod: "PUT", RequestHeader: "X-Custom",
+			Handled: true, Code: http.StatusForbidden, AllowOrigin: "http://localhost:3000"},
+		{Name: "PreflightOrigin", Method: "OPTIONS", Origin: "https://example.org", RequestMethod: "PUT",
+			Handled: true, Code: http.StatusForbidden},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			req := httptest.NewRequest(row.Method, "/ws1/data.json", nil)
+			if row.Origin != "" {
+				req.Header.Set("Origin", row.Origin)
+			}
+			if row.RequestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", row.RequestMethod)
+			}
+			if row.RequestHeader != "" {
+				req.Header.Set("Access-Control-Request-Headers", row.RequestHeader)
+			}
+			res := httptest.NewRecorder()
+
+			handled := policy.Handle(res, req)
+			require.Equal(t, row.Handled, handled)
+			if handled {
+				require.Equal(t, row.Code, res.Code)
+			}
+			require.Equal(t, row.AllowOrigin, res.Header().Get("Access-Control-Allow-Origin"))
+			if row.Code == http.StatusNoContent {
+				require.Equal(t, "GET, PUT", res.Header().Get("Access-Control-Allow-Methods"))
+				require.Equal(t, "600", res.Header().Get("Access-Control-Max-Age"))
+				require.Equal(t, "true", res.Header().Get("Access-Control-Allow-Credentials"))
+			}
+			if !handled && row.AllowOrigin != "" {
+				require.Equal(t, "Location", res.Header().Get("Access-Control-Expose-Headers"))
+			}
+		})
+	}
+}
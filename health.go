@@ -0,0 +1,106 @@
+package makeshiftd
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/makeshiftd/makeshiftd/context"
+)
+
+// Health statuses reported by the health endpoints
+const (
+	HealthOK       = "ok"
+	HealthDraining = "draining"
+	HealthStopped  = "stopped"
+	HealthFailing  = "failing"
+)
+
+// WatchShutdown configures the health endpoints to report not ready when the
+// server context is done, so that load balancers stop sending requests, and
+// not live when the shutdown context is done
+func (m *Makeshiftd) WatchShutdown(serverCtx, shutdownCtx context.C) {
+	m.healthMtx.Lock()
+	defer m.healthMtx.Unlock()
+	m.serverCtx = serverCtx
+	m.shutdownCtx = shutdownCtx
+}
+
+// Shutdown cancels the workspaces when the shutdown of the server begins, to
+// end long-lived requests, such as event streams, which would otherwise delay
+// the shutdown, the workspaces keep serving while the server is draining
+func (m *Makeshiftd) Shutdown() {
+	for _, w := range m.Workspaces() {
+		w.Cancel()
+	}
+}
+
+func (m *Makeshiftd) healthContexts() (context.C, context.C) {
+	m.healthMtx.RLock()
+	defer m.healthMtx.RUnlock()
+	serverCtx, shutdownCtx := m.serverCtx, m.shutdownCtx
+	if serverCtx == nil {
+		serverCtx = context.Background()
+	}
+	if shutdownCtx == nil {
+		shutdownCtx = context.Background()
+	}
+	return serverCtx, shutdownCtx
+}
+
+type healthStatus struct {
+	Status     string            `json:"status"`
+	Workspaces map[string]string `json:"workspaces,omitempty"`
+}
+
+// ServeHealthz serves the liveness status, the service is live
+// until the shutdown is complete or has been forced
+func (m *Makeshiftd) ServeHealthz(res http.ResponseWriter, req *http.Request) {
+	_, shutdownCtx := m.healthContexts()
+
+	status := healthStatus{Status: HealthOK}
+	if shutdownCtx.Err() != nil {
+		status.Status = HealthStopped
+	}
+	m.serveHealth(status, res, req)
+}
+
+// ServeReadyz serves the readiness status, the service is not ready once
+// shutdown has started, the status of each workspace is reported but a
+// workspace that is not accessible does not affect the other workspaces
+func (m *Makeshiftd) ServeReadyz(res http.ResponseWriter, req *http.Request) {
+	serverCtx, _ := m.healthContexts()
+
+	status := healthStatus{
+		Status:     HealthOK,
+		Workspaces: map[string]string{},
+	}
+	for _, w := range m.Workspaces() {
+		if err := w.Check(); err != nil {
+			status.Workspaces[w.Slug] = HealthFailing + ": " + err.Error()
+		} else {
+			status.Workspaces[w.Slug] = HealthOK
+		}
+	}
+	if serverCtx.Err() != nil {
+		status.Status = HealthDraining
+	}
+	m.serveHealth(status, res, req)
+}
+
+func (m *Makeshiftd) serveHealth(status healthStatus, res http.ResponseWriter, req *http.Request) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		m.ServeError(err, res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	if status.Status == HealthOK {
+		res.WriteHeader(http.StatusOK)
+	} else {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if req.Method != "HEAD" {
+		res.Write(data)
+	}
+}
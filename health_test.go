@@ -0,0 +1,109 @@
+package makeshiftd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/makeshiftd/makeshiftd/context"
+	"github.com/makeshiftd/makeshiftd/workspace"
+)
+
+func newTestMakeshiftd(t *testing.T) *Makeshiftd {
+	config := viper.New()
+	config.SetConfigFile(filepath.Join("testdata", "makeshiftd.json"))
+	err := config.ReadInConfig()
+	require.Nil(t, err, err)
+	return New(config)
+}
+
+func TestHealth(t *testing.T) {
+	m := newTestMakeshiftd(t)
+
+	serverCtx, serverCancel := context.WithCancel(context.Background())
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	m.WatchShutdown(serverCtx, shutdownCtx)
+
+	check := func(path string, code int) {
+		res := httptest.NewRecorder()
+		m.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, code, res.Code, "%s: %s", path, res.Body.String())
+	}
+
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusOK)
+
+	serverCancel()
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusServiceUnavailable)
+
+	shutdownCancel()
+	check("/healthz", http.StatusServiceUnavailable)
+	check("/readyz", http.StatusServiceUnavailable)
+}
+
+func TestShutdown(t *testing.T) {
+	m := newTestMakeshiftd(t)
+
+	serverCtx, serverCancel := context.WithCancel(context.Background())
+	m.WatchShutdown(serverCtx, context.Background())
+
+	done := make(chan bool)
+	go func() {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws1/"+workspace.EventsAPI, nil))
+		close(done)
+	}()
+
+	// The event streams are served while the server is draining
+	serverCancel()
+	select {
+	case <-done:
+		t.Fatal("Event stream ended when draining")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	m.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event stream not ended on shutdown")
+	}
+}
+
+func TestReadyzWorkspaces(t *testing.T) {
+	config := viper.New()
+	config.Set("workspaces", map[string]interface{}{
+		"ws1":     "testdata/workspace1",
+		"missing": "testdata/missing",
+	})
+	m := New(config)
+
+	res := httptest.NewRecorder()
+	m.ServeHTTP(res, httptest.NewRequest("GET", "/readyz", nil))
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	status := healthStatus{}
+	err := json.Unmarshal(res.Body.Bytes(), &status)
+	require.Nil(t, err, err)
+	require.Equal(t, HealthOK, status.Status)
+	require.Equal(t, HealthOK, status.Workspaces["ws1"])
+	require.Contains(t, status.Workspaces["missing"], HealthFailing)
+}
+
+func TestReservedSlugs(t *testing.T) {
+	require.Nil(t, newTestMakeshiftd(t).Check())
+
+	for _, slug := range []string{"healthz", "readyz", "metrics", "_admin", "Metrics"} {
+		t.Run(slug, func(t *testing.T) {
+			config := viper.New()
+			config.Set("workspaces", map[string]interface{}{slug: "testdata/workspace1"})
+			require.NotNil(t, New(config).Check())
+		})
+	}
+}
@@ -12,6 +12,8 @@ import (
 // LazyLogger wraps a zerolog Logger and initializes it to the root logger on first use.
 type LazyLogger struct {
 	logger zerolog.Logger
+	name   string
+	entry  *entry
 
 	configFunc func()
 	configOnce sync.Once
@@ -19,7 +21,14 @@ type LazyLogger struct {
 
 // NewLazyLogger builds a new LazyLogger an applies the provided configuration on initialization
 func NewLazyLogger(config func(ctx zerolog.Context) zerolog.Context) *LazyLogger {
-	l := &LazyLogger{}
+	return newLazyLogger("", config)
+}
+
+func newLazyLogger(name string, config func(ctx zerolog.Context) zerolog.Context) *LazyLogger {
+	l := &LazyLogger{name: name}
+	if name != "" {
+		l.entry = lookup(name)
+	}
 	l.configFunc = func() {
 		c := log.Logger.With()
 		if config != nil {
@@ -30,16 +39,42 @@ func NewLazyLogger(config func(ctx zerolog.Context) zerolog.Context) *LazyLogger
 	return l
 }
 
-// NewLazyLoggerPkg builds a new LazyLogger and configures it with the 'pkg' provided
+// NewLazyLoggerPkg builds a new LazyLogger and configures it with the 'pkg' provided,
+// the logger is registered with the 'pkg' as its name so that its level can be set
 func NewLazyLoggerPkg(pkg string) *LazyLogger {
-	return NewLazyLogger(func(ctx zerolog.Context) zerolog.Context {
+	return newLazyLogger(pkg, func(ctx zerolog.Context) zerolog.Context {
 		return ctx.Str("pkg", pkg)
 	})
 }
 
+// GetLevel returns the current level of the logger
+func (l *LazyLogger) GetLevel() zerolog.Level {
+	if l.entry == nil {
+		return DefaultLevel()
+	}
+	return l.entry.get()
+}
+
 func (l *LazyLogger) log() *zerolog.Logger {
 	l.configOnce.Do(l.configFunc)
-	return &l.logger
+	logger := l.logger.Level(l.GetLevel())
+	return &logger
+}
+
+// Ctx returns the logger associated with the ctx with the 'pkg' of this logger
+// added and the level of this logger applied. If no logger is associated,
+// this logger is returned.
+func (l *LazyLogger) Ctx(ctx context.Context) *zerolog.Logger {
+	logger := zerolog.Ctx(ctx)
+	if logger.GetLevel() == zerolog.Disabled {
+		return l.log()
+	}
+	c := logger.With()
+	if l.name != "" {
+		c = c.Str("pkg", l.name)
+	}
+	child := c.Logger().Level(l.GetLevel())
+	return &child
 }
 
 // Functions and docs below mostly copied from Zerolog source code
@@ -0,0 +1,138 @@
+package loggers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// levelUnset indicates that a named logger uses the default level
+const levelUnset = int32(-128)
+
+// Range of levels that can be set by stepping
+const (
+	minStepLevel = zerolog.TraceLevel
+	maxStepLevel = zerolog.PanicLevel
+)
+
+type entry struct {
+	level int32
+}
+
+func (e *entry) get() zerolog.Level {
+	level := atomic.LoadInt32(&e.level)
+	if level == levelUnset {
+		return DefaultLevel()
+	}
+	return zerolog.Level(level)
+}
+
+var defaultLevel = int32(zerolog.TraceLevel)
+
+var registry = map[string]*entry{}
+var registryMtx sync.RWMutex
+
+// lookup returns the registry entry for the named logger, the entry is
+// created if it does not exist so that levels can be set before use
+func lookup(name string) *entry {
+	registryMtx.RLock()
+	e := registry[name]
+	registryMtx.RUnlock()
+	if e != nil {
+		return e
+	}
+
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	e = registry[name]
+	if e == nil {
+		e = &entry{level: levelUnset}
+		registry[name] = e
+	}
+	return e
+}
+
+// DefaultLevel returns the level of loggers for which no level has been set
+func DefaultLevel() zerolog.Level {
+	return zerolog.Level(atomic.LoadInt32(&defaultLevel))
+}
+
+// SetDefaultLevel sets the level of loggers for which no level has been set
+func SetDefaultLevel(level zerolog.Level) {
+	atomic.StoreInt32(&defaultLevel, int32(level))
+}
+
+// Level returns the level of the named logger
+func Level(name string) zerolog.Level {
+	return lookup(name).get()
+}
+
+// SetLevel sets the level of the named logger
+func SetLevel(name string, level zerolog.Level) {
+	atomic.StoreInt32(&lookup(name).level, int32(level))
+}
+
+// ResetLevel resets the named logger to use the default level
+func ResetLevel(name string) {
+	atomic.StoreInt32(&lookup(name).level, levelUnset)
+}
+
+// Levels returns the levels of all the named loggers
+func Levels() map[string]zerolog.Level {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	levels := map[string]zerolog.Level{}
+	for name, e := range registry {
+		levels[name] = e.get()
+	}
+	return levels
+}
+
+// Names returns the sorted names of all the named loggers
+func Names() []string {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StepLevels changes the default level and the levels of all named loggers
+// by the given number of steps, a negative step increases the verbosity
+func StepLevels(step int) {
+	SetDefaultLevel(stepLevel(DefaultLevel(), step))
+
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+	for _, e := range registry {
+		level := atomic.LoadInt32(&e.level)
+		if level != levelUnset {
+			atomic.StoreInt32(&e.level, int32(stepLevel(zerolog.Level(level), step)))
+		}
+	}
+}
+
+func stepLevel(level zerolog.Level, step int) zerolog.Level {
+	stepped := int(level) + step
+	if stepped < int(minStepLevel) {
+		return minStepLevel
+	}
+	if stepped > int(maxStepLevel) {
+		return maxStepLevel
+	}
+	return zerolog.Level(stepped)
+}
+
+// ParseLevel parses the level name, unlike zerolog the empty name is not valid
+func ParseLevel(name string) (zerolog.Level, error) {
+	if name == "" {
+		return zerolog.NoLevel, fmt.Errorf("Log level required")
+	}
+	return zerolog.ParseLevel(name)
+}
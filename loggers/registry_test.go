@@ -0,0 +1,50 @@
+package loggers
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLevels(t *testing.T) {
+	defer SetDefaultLevel(DefaultLevel())
+	SetDefaultLevel(zerolog.InfoLevel)
+
+	l := NewLazyLoggerPkg("registry-test")
+	require.Equal(t, zerolog.InfoLevel, l.GetLevel())
+	require.Contains(t, Names(), "registry-test")
+
+	SetLevel("registry-test", zerolog.TraceLevel)
+	require.Equal(t, zerolog.TraceLevel, l.GetLevel())
+	require.Equal(t, zerolog.TraceLevel, Levels()["registry-test"])
+
+	StepLevels(1)
+	require.Equal(t, zerolog.DebugLevel, l.GetLevel())
+	require.Equal(t, zerolog.WarnLevel, DefaultLevel())
+
+	StepLevels(-10)
+	require.Equal(t, zerolog.TraceLevel, l.GetLevel())
+	require.Equal(t, zerolog.TraceLevel, DefaultLevel())
+
+	ResetLevel("registry-test")
+	SetDefaultLevel(zerolog.ErrorLevel)
+	require.Equal(t, zerolog.ErrorLevel, l.GetLevel())
+}
+
+func TestCtx(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := zerolog.New(out)
+	ctx := logger.WithContext(context.Background())
+
+	l := NewLazyLoggerPkg("registry-ctx-test")
+	SetLevel("registry-ctx-test", zerolog.InfoLevel)
+
+	l.Ctx(ctx).Debug().Msg("hidden")
+	require.Empty(t, out.String())
+
+	l.Ctx(ctx).Info().Msg("shown")
+	require.Equal(t, `{"level":"info","pkg":"registry-ctx-test","message":"shown"}`+"\n", out.String())
+}
@@ -1,6 +1,7 @@
 package makeshiftd
 
 import (
+	"fmt"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -8,7 +9,9 @@ import (
 
 	"github.com/spf13/viper"
 
+	"github.com/makeshiftd/makeshiftd/context"
 	"github.com/makeshiftd/makeshiftd/loggers"
+	"github.com/makeshiftd/makeshiftd/metrics"
 	"github.com/makeshiftd/makeshiftd/urlpath"
 	"github.com/makeshiftd/makeshiftd/workspace"
 )
@@ -20,28 +23,75 @@ type Makeshiftd struct {
 	config        *viper.Viper
 	workspaces    []*workspace.Workspace
 	workspacesMtx sync.RWMutex
+
+	serverCtx   context.C
+	shutdownCtx context.C
+	healthMtx   sync.RWMutex
 }
 
+// inheritedKeys are the options of the global configuration that
+// apply to each workspace unless overridden by the workspace
+var inheritedKeys = []string{"collections", "cors", "dev", "history", "limits", "quota", "webdav"}
+
+// MetricsSlug is the first path segment of the metrics endpoint
+const MetricsSlug = "metrics"
+
+// serviceSlugs are the first path segments of the service endpoints,
+// which are served by every listener
+var serviceSlugs = []string{"healthz", "readyz", MetricsSlug}
+
+// reservedSlugs are the first path segments served by the service,
+// a workspace with a reserved slug would never be served
+var reservedSlugs = append([]string{AdminSlug}, serviceSlugs...)
+
 // New creates a new Makeshiftd service from the configuration
 func New(config *viper.Viper) *Makeshiftd {
 	m := &Makeshiftd{
 		config: config,
 	}
 
-	wsconfig := config.GetStringMapString("workspaces")
-	for name, root := range wsconfig {
+	for name := range config.GetStringMap("workspaces") {
+		// A workspace is configured with either the path of the root
+		// directory or an object with the root and other options.
+		key := "workspaces." + name
+		wsconfig := config.Sub(key)
+		var root string
+		if wsconfig != nil {
+			root = wsconfig.GetString("root")
+		} else {
+			root = config.GetString(key)
+			wsconfig = viper.New()
+		}
+		for _, inherited := range inheritedKeys {
+			if !wsconfig.IsSet(inherited) && config.IsSet(inherited) {
+				wsconfig.Set(inherited, config.Get(inherited))
+			}
+		}
 		if !filepath.IsAbs(root) {
 			configFileDir := filepath.Dir(config.ConfigFileUsed())
 			root = filepath.Join(configFileDir, root)
 		}
 		root = filepath.Clean(root)
 
-		w := workspace.New(m, name, root)
+		w := workspace.New(m, name, root, wsconfig)
 		m.workspaces = append(m.workspaces, w)
 	}
 	return m
 }
 
+// Check returns an error if the configuration of the service is invalid,
+// the errors of the individual workspaces are reported by the workspaces
+func (m *Makeshiftd) Check() error {
+	for _, w := range m.Workspaces() {
+		for _, slug := range reservedSlugs {
+			if w.Slug == slug {
+				return fmt.Errorf("Workspace slug is reserved: %s", w.Name)
+			}
+		}
+	}
+	return nil
+}
+
 // Workspaces returns a copy the the configured workspaces
 func (m *Makeshiftd) Workspaces() []*workspace.Workspace {
 	m.workspacesMtx.RLock()
@@ -63,6 +113,40 @@ func (m *Makeshiftd) match(slug string) *workspace.Workspace {
 	return nil
 }
 
+// Restrict returns a handler that serves only the named workspaces,
+// requests for other workspaces are not found. If no workspaces are
+// named then the returned handler serves all workspaces. The service
+// endpoints are always served, the admin API is served to any
+// principal if the admin slug is explicitly named.
+func (m *Makeshiftd) Restrict(slugs []string) http.Handler {
+	if len(slugs) == 0 {
+		return m
+	}
+	allowed := map[string]bool{}
+	for _, slug := range slugs {
+		allowed[strings.ToLower(slug)] = true
+	}
+	service := map[string]bool{}
+	for _, slug := range serviceSlugs {
+		service[slug] = true
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		slug, _ := urlpath.PopLeft(req.URL.Path)
+		slug = strings.ToLower(slug)
+		if slug != "" && !allowed[slug] && !service[slug] {
+			log := log.Ctx(req.Context())
+			log.Debug().Msgf("Workspace not allowed: %s", req.URL.Path)
+			m.ServeError(http.StatusNotFound, res, req)
+			return
+		}
+		ctx := workspace.WithAllowedSlugs(req.Context(), allowed)
+		if allowed[AdminSlug] {
+			ctx = withAdminListener(ctx)
+		}
+		m.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
+
 func (m *Makeshiftd) ServeIndex(res http.ResponseWriter, req *http.Request) {
 	res.WriteHeader(http.StatusOK)
 	res.Write([]byte("Makeshiftd"))
@@ -83,6 +167,7 @@ func (m *Makeshiftd) ServeError(cause interface{}, res http.ResponseWriter, req
 }
 
 func (m *Makeshiftd) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
 	log.Debug().Msgf("Serve HTTP path: %s", req.URL.Path)
 	slug, path := urlpath.PopLeft(req.URL.Path)
 	slug = strings.ToLower(slug)
@@ -92,6 +177,27 @@ func (m *Makeshiftd) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	switch slug {
+	case "healthz":
+		m.ServeHealthz(res, req)
+		return
+	case "readyz":
+		m.ServeReadyz(res, req)
+		return
+	}
+
+	if slug == AdminSlug && m.config.GetBool("admin.enabled") {
+		req.URL.Path = path
+		m.ServeAdmin(res, req)
+		return
+	}
+
+	// The metrics may be served by a separate listener
+	if slug == MetricsSlug && m.config.GetBool("metrics.enabled") && m.config.GetString("metrics.listen") == "" {
+		metrics.Handler().ServeHTTP(res, req)
+		return
+	}
+
 	w := m.match(slug)
 	if w == nil {
 		log.Debug().Msgf("Workspace not found: %s", req.URL.Path)
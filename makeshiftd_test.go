@@ -0,0 +1,39 @@
+package makeshiftd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestrict(t *testing.T) {
+	m := newTestMakeshiftd(t)
+	m.config.Set("metrics.enabled", true)
+
+	table := []struct {
+		Name  string
+		Slugs []string
+		Path  string
+		Code  int
+	}{
+		{Name: "Unrestricted", Path: "/ws1/data.json", Code: http.StatusOK},
+		{Name: "Allowed", Slugs: []string{"ws1"}, Path: "/ws1/data.json", Code: http.StatusOK},
+		{Name: "AllowedCase", Slugs: []string{"WS1"}, Path: "/ws1/data.json", Code: http.StatusOK},
+		{Name: "NotAllowed", Slugs: []string{"ws2"}, Path: "/ws1/data.json", Code: http.StatusNotFound},
+		{Name: "Index", Slugs: []string{"ws2"}, Path: "/", Code: http.StatusOK},
+		{Name: "Healthz", Slugs: []string{"ws2"}, Path: "/healthz", Code: http.StatusOK},
+		{Name: "Metrics", Slugs: []string{"ws2"}, Path: "/" + MetricsSlug, Code: http.StatusOK},
+		{Name: "Admin", Slugs: []string{"ws2"}, Path: "/" + AdminSlug + "/loglevels", Code: http.StatusNotFound},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", row.Path, nil)
+			res := httptest.NewRecorder()
+			m.Restrict(row.Slugs).ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code, res.Body.String())
+		})
+	}
+}
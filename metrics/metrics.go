@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/makeshiftd/makeshiftd/request"
+)
+
+const namespace = "makeshiftd"
+
+// Registry is the registry of the Makeshiftd metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Requests counts the requests served by workspace, method and status code
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests served.",
+	}, []string{"workspace", "method", "code"})
+
+	// RequestDuration observes the request latency by workspace, method and status code
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests served.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"workspace", "method", "code"})
+
+	// RequestBytes counts the bytes read from request bodies by workspace
+	RequestBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_request_bytes_total",
+		Help:      "Number of bytes read from HTTP request bodies.",
+	}, []string{"workspace"})
+
+	// ResponseBytes counts the bytes written to response bodies by workspace
+	ResponseBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_response_bytes_total",
+		Help:      "Number of bytes written to HTTP response bodies.",
+	}, []string{"workspace"})
+
+	// InFlight is the number of requests currently served by the serve workgroup
+	InFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of HTTP requests currently being served.",
+	})
+
+	// Execs counts the exec document invocations by executer and result
+	Execs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "exec_total",
+		Help:      "Number of exec document invocations.",
+	}, []string{"executer", "result"})
+
+	// ExecDuration observes the exec document duration by executer
+	ExecDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "exec_duration_seconds",
+		Help:      "Duration of exec document invocations.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"executer"})
+
+	// WrittenBytes counts the bytes written to documents by workspace
+	WrittenBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workspace_written_bytes_total",
+		Help:      "Number of bytes written to workspace documents.",
+	}, []string{"workspace"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		Requests,
+		RequestDuration,
+		RequestBytes,
+		ResponseBytes,
+		InFlight,
+		Execs,
+		ExecDuration,
+		WrittenBytes,
+	)
+}
+
+// Handler returns a handler that serves the metrics in the text exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Instrument returns a handler that records the request metrics of the handler
+func Instrument(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx, info := request.WithInfo(req.Context())
+		req = req.WithContext(ctx)
+
+		var body *request.Counter
+		if req.Body != nil {
+			body = &request.Counter{ReadCloser: req.Body}
+			req.Body = body
+		}
+
+		start := time.Now()
+		rec := &request.Recorder{ResponseWriter: res}
+		handler.ServeHTTP(rec, req)
+		duration := time.Since(start)
+
+		code := strconv.Itoa(rec.Status())
+		method := methodLabel(req.Method)
+		Requests.WithLabelValues(info.Workspace, method, code).Inc()
+		RequestDuration.WithLabelValues(info.Workspace, method, code).Observe(duration.Seconds())
+		ResponseBytes.WithLabelValues(info.Workspace).Add(float64(rec.Bytes))
+		if body != nil {
+			RequestBytes.WithLabelValues(info.Workspace).Add(float64(body.Bytes))
+		}
+	})
+}
+
+// methodLabel limits the method label to the known methods
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/makeshiftd/makeshiftd/request"
+)
+
+func scrape(t *testing.T) map[string]*dto.MetricFamily {
+	res := httptest.NewRecorder()
+	Handler().ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	parser := expfmt.TextParser{}
+	families, err := parser.TextToMetricFamilies(res.Body)
+	require.Nil(t, err, err)
+	return families
+}
+
+func findMetric(family *dto.MetricFamily, labels map[string]string) *dto.Metric {
+	for _, m := range family.Metric {
+		matched := 0
+		for _, l := range m.Label {
+			if labels[l.GetName()] == l.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestInstrument(t *testing.T) {
+	handler := Instrument(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		request.Ctx(req.Context()).Workspace = "metrics-test"
+		io.ReadAll(req.Body)
+		res.WriteHeader(http.StatusCreated)
+		res.Write([]byte("Hello World"))
+	}))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("PUT", "/metrics-test/data.json", strings.NewReader("12345"))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	families := scrape(t)
+
+	requests := findMetric(families["makeshiftd_http_requests_total"],
+		map[string]string{"workspace": "metrics-test", "method": "PUT", "code": "201"})
+	require.NotNil(t, requests)
+	require.Equal(t, float64(3), requests.GetCounter().GetValue())
+
+	duration := findMetric(families["makeshiftd_http_request_duration_seconds"],
+		map[string]string{"workspace": "metrics-test", "method": "PUT", "code": "201"})
+	require.NotNil(t, duration)
+	require.Equal(t, uint64(3), duration.GetHistogram().GetSampleCount())
+
+	requestBytes := findMetric(families["makeshiftd_http_request_bytes_total"],
+		map[string]string{"workspace": "metrics-test"})
+	require.NotNil(t, requestBytes)
+	require.Equal(t, float64(15), requestBytes.GetCounter().GetValue())
+
+	responseBytes := findMetric(families["makeshiftd_http_response_bytes_total"],
+		map[string]string{"workspace": "metrics-test"})
+	require.NotNil(t, responseBytes)
+	require.Equal(t, float64(33), responseBytes.GetCounter().GetValue())
+
+	require.Contains(t, families, "makeshiftd_http_requests_in_flight")
+}
@@ -0,0 +1,63 @@
+package request
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/makeshiftd/makeshiftd/loggers"
+)
+
+// IDHeader is the header containing the request ID
+const IDHeader = "X-Request-ID"
+
+const maxIDLength = 200
+
+// Identify returns a handler that assigns an ID to each request, an ID
+// provided by the client in the request header is used if it is valid.
+// The ID is set in the response header and a child logger with the ID
+// and request path is associated with the request context.
+func Identify(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx, info := WithInfo(req.Context())
+
+		id := req.Header.Get(IDHeader)
+		if !validID(id) {
+			id = NewID()
+		}
+		info.ID = id
+		res.Header().Set(IDHeader, id)
+
+		log := loggers.Ctx(ctx).With().Str("req", id).Str("path", req.URL.Path).Logger()
+		ctx = log.WithContext(ctx)
+
+		handler.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
+
+// NewID returns a new random request ID
+func NewID() string {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '@' || c == '/' || c == '+' || c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentify(t *testing.T) {
+	table := []struct {
+		Header string
+		Reused bool
+	}{
+		{Header: "", Reused: false},
+		{Header: "abc-123", Reused: true},
+		{Header: "invalid id\n", Reused: false},
+	}
+
+	for _, row := range table {
+		t.Run("Header:"+row.Header, func(t *testing.T) {
+			var id string
+			handler := Identify(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				id = Ctx(req.Context()).ID
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if row.Header != "" {
+				req.Header.Set(IDHeader, row.Header)
+			}
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			require.NotEmpty(t, id)
+			require.Equal(t, id, res.Header().Get(IDHeader))
+			if row.Reused {
+				require.Equal(t, row.Header, id)
+			} else {
+				require.NotEqual(t, row.Header, id)
+			}
+		})
+	}
+}
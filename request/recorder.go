@@ -0,0 +1,76 @@
+package request
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Recorder is a response writer that records the status and
+// number of bytes written to the wrapped response writer
+type Recorder struct {
+	http.ResponseWriter
+	StatusCode int
+	Bytes      int64
+}
+
+// Status returns the response status code
+func (r *Recorder) Status() int {
+	if r.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return r.StatusCode
+}
+
+// WriteHeader records the status code and writes the header
+func (r *Recorder) WriteHeader(code int) {
+	if r.StatusCode == 0 {
+		r.StatusCode = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the number of bytes written
+func (r *Recorder) Write(b []byte) (int, error) {
+	if r.StatusCode == 0 {
+		r.StatusCode = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	return n, err
+}
+
+// Flush flushes the wrapped response writer if supported
+func (r *Recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the connection of the wrapped response writer if supported
+func (r *Recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("Response writer does not support hijack")
+}
+
+// Unwrap returns the wrapped response writer
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Counter is a reader that counts the number of bytes read from the wrapped reader
+type Counter struct {
+	io.ReadCloser
+	Bytes int64
+}
+
+// Read records the number of bytes read
+func (c *Counter) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.Bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,34 @@
+package request
+
+import (
+	"context"
+)
+
+// Info is the information about a request that is collected by the
+// handlers while the request is served, for example for access logging
+type Info struct {
+	ID        string
+	Workspace string
+	Exec      bool
+}
+
+type infoKey struct{}
+
+// WithInfo returns a copy of the context associated with new request info,
+// if the context already has associated info then it is returned unchanged
+func WithInfo(ctx context.Context) (context.Context, *Info) {
+	if info, ok := ctx.Value(infoKey{}).(*Info); ok {
+		return ctx, info
+	}
+	info := &Info{}
+	return context.WithValue(ctx, infoKey{}, info), info
+}
+
+// Ctx returns the request info associated with the context. If no info is
+// associated, a new info is returned so that it can be updated regardless.
+func Ctx(ctx context.Context) *Info {
+	if info, ok := ctx.Value(infoKey{}).(*Info); ok {
+		return info
+	}
+	return &Info{}
+}
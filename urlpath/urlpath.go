@@ -17,6 +17,47 @@ func Split(path string) (string, string) {
 	return urlpath.Split(path)
 }
 
+// Match reports whether the path matches the shell pattern, the pattern
+// syntax is the same as path.Match with the addition of the '**' segment
+// which matches zero or more path segments. Leading slashes are ignored.
+func Match(pattern, path string) (bool, error) {
+	pattern = strings.Trim(pattern, "/")
+	path = strings.Trim(path, "/")
+
+	var patSegs, pathSegs []string
+	if pattern != "" {
+		patSegs = strings.Split(pattern, "/")
+	}
+	if path != "" {
+		pathSegs = strings.Split(path, "/")
+	}
+	return matchSegments(patSegs, pathSegs)
+}
+
+func matchSegments(patSegs, pathSegs []string) (bool, error) {
+	for len(patSegs) > 0 {
+		if patSegs[0] == "**" {
+			for idx := 0; idx <= len(pathSegs); idx++ {
+				matched, err := matchSegments(patSegs[1:], pathSegs[idx:])
+				if err != nil || matched {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+		if len(pathSegs) == 0 {
+			return false, nil
+		}
+		matched, err := urlpath.Match(patSegs[0], pathSegs[0])
+		if err != nil || !matched {
+			return false, err
+		}
+		patSegs = patSegs[1:]
+		pathSegs = pathSegs[1:]
+	}
+	return len(pathSegs) == 0, nil
+}
+
 func PopLeft(path string) (string, string) {
 	idx := strings.Index(path, "/")
 	if idx < 0 {
@@ -32,3 +32,31 @@ func TestPopLeft(t *testing.T) {
 	}
 
 }
+
+func TestMatch(t *testing.T) {
+	table := []struct {
+		Pattern string
+		Path    string
+		Matched bool
+	}{
+		{Pattern: "**", Path: "", Matched: true},
+		{Pattern: "**", Path: "/a/b/c", Matched: true},
+		{Pattern: "/a/*", Path: "/a/b", Matched: true},
+		{Pattern: "/a/*", Path: "/a/b/c", Matched: false},
+		{Pattern: "a/**", Path: "/a", Matched: true},
+		{Pattern: "a/**/c.json", Path: "a/c.json", Matched: true},
+		{Pattern: "a/**/c.json", Path: "a/b/b/c.json", Matched: true},
+		{Pattern: "a/**/c.json", Path: "a/b/b/d.json", Matched: false},
+		{Pattern: "**/*.html", Path: "/index.html", Matched: true},
+		{Pattern: "*.html", Path: "/a/index.html", Matched: false},
+		{Pattern: "", Path: "", Matched: true},
+	}
+
+	for _, row := range table {
+		t.Run("Pattern:"+row.Pattern+",Path:"+row.Path, func(t *testing.T) {
+			matched, err := Match(row.Pattern, row.Path)
+			require.Nil(t, err, err)
+			require.Equal(t, row.Matched, matched)
+		})
+	}
+}
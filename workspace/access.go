@@ -0,0 +1,240 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// AccessFileName is the name of the hidden file containing the access
+// rules for the directory in which it is located
+const AccessFileName = "_access.json"
+
+// Access methods used in access rules, in addition to the HTTP methods
+const (
+	AccessExec = "EXEC"
+	AccessAny  = "*"
+)
+
+// Access rule effects
+const (
+	AccessAllow = "allow"
+	AccessDeny  = "deny"
+)
+
+// AccessRule pairs document path patterns and methods with the principals
+// to which the rule applies. Paths are relative to the directory in which
+// the rule is defined and may use '**' to match any number of segments.
+// Principals are names, groups prefixed with 'group:', 'authenticated'
+// for any authenticated principal or '*' for everyone.
+type AccessRule struct {
+	Paths      []string `json:"paths" mapstructure:"paths"`
+	Methods    []string `json:"methods" mapstructure:"methods"`
+	Principals []string `json:"principals" mapstructure:"principals"`
+	Effect     string   `json:"effect" mapstructure:"effect"`
+}
+
+// AccessConfig is the access control configuration of a workspace,
+// the default effect applies when no rule matches a request
+type AccessConfig struct {
+	Default string       `json:"default" mapstructure:"default"`
+	Rules   []AccessRule `json:"rules" mapstructure:"rules"`
+}
+
+// validate returns an error if the default or the effect of a rule is neither
+// allow nor deny, so that a misspelled effect does not allow the requests, an
+// omitted effect allows
+func (c *AccessConfig) validate() error {
+	valid := func(effect string) bool {
+		return effect == "" || strings.EqualFold(effect, AccessAllow) || strings.EqualFold(effect, AccessDeny)
+	}
+	if !valid(c.Default) {
+		return fmt.Errorf("Access default invalid: %q", c.Default)
+	}
+	for _, rule := range c.Rules {
+		if !valid(rule.Effect) {
+			return fmt.Errorf("Access rule effect invalid: %q", rule.Effect)
+		}
+	}
+	return nil
+}
+
+type accessRules struct {
+	dir   string
+	rules []AccessRule
+}
+
+func (r *AccessRule) match(p auth.Principal, method, path string) (bool, error) {
+	if !r.matchMethod(method) || !r.matchPrincipal(p) {
+		return false, nil
+	}
+	if len(r.Paths) == 0 {
+		return true, nil
+	}
+	for _, pattern := range r.Paths {
+		matched, err := urlpath.Match(pattern, path)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func (r *AccessRule) matchMethod(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == AccessAny || strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *AccessRule) matchPrincipal(p auth.Principal) bool {
+	for _, name := range r.Principals {
+		switch {
+		case name == "*":
+			return true
+		case name == "authenticated":
+			if !p.IsAnonymous() {
+				return true
+			}
+		case strings.HasPrefix(name, "group:"):
+			if p.InGroup(name[len("group:"):]) {
+				return true
+			}
+		case name == p.Name:
+			return true
+		}
+	}
+	return false
+}
+
+// accessMethod returns the access method for the request method,
+// the metadata of an exec document only requires read access and
+// the source of a move requires delete access
+func accessMethod(method string, exec bool) string {
+	if exec && method != "HEAD" {
+		return AccessExec
+	}
+	switch method {
+	case "HEAD", "COPY", "PROPFIND":
+		return "GET"
+	case "PATCH", "PROPPATCH", "MKCOL", "LOCK", "UNLOCK":
+		return "PUT"
+	case "MOVE":
+		return "DELETE"
+	}
+	return method
+}
+
+// authorize evaluates the access rules for the document path, the rules
+// of the workspace configuration and of each directory along the path
+// are inherited, a matching deny rule overrides any matching allow rule.
+func (w *Workspace) authorize(p auth.Principal, method, docPath string) (bool, error) {
+	docPath = strings.Trim(docPath, "/")
+
+	rulesets := []accessRules{{dir: "", rules: w.access.Rules}}
+
+	dir := ""
+	segments := []string{""}
+	if docPath != "" {
+		segments = append(segments, strings.Split(docPath, "/")...)
+	}
+	for _, segment := range segments {
+		dir = urlpath.Join(dir, segment)
+		rules, err := w.readAccessFile(dir)
+		if err != nil {
+			return false, err
+		}
+		if len(rules) > 0 {
+			rulesets = append(rulesets, accessRules{dir: dir, rules: rules})
+		}
+	}
+
+	matched := false
+	for _, ruleset := range rulesets {
+		path := strings.TrimPrefix(strings.TrimPrefix(docPath, ruleset.dir), "/")
+		for idx := range ruleset.rules {
+			rule := &ruleset.rules[idx]
+			ok, err := rule.match(p, method, path)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+			if strings.EqualFold(rule.Effect, AccessDeny) {
+				return false, nil
+			}
+			matched = true
+		}
+	}
+	if !matched {
+		return !strings.EqualFold(w.access.Default, AccessDeny), nil
+	}
+	return true, nil
+}
+
+// authorizeTree evaluates the access rules for each file of the tree at the
+// file path as the document it is copied to at the path, so that the rules
+// of the nested directories are not bypassed by copying the directory, a tree
+// with hidden files is denied so that the access files are not copied
+func (w *Workspace) authorizeTree(p auth.Principal, method, filePath string, info os.FileInfo, docPath string) (bool, error) {
+	if !info.IsDir() {
+		return w.authorize(p, method, docPath)
+	}
+	allowed := true
+	err := filepath.WalkDir(filePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != filePath && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+			allowed = false
+			return filepath.SkipAll
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(filePath, path)
+		if err != nil {
+			return err
+		}
+		allowed, err = w.authorize(p, method, urlpath.Join(docPath, filepath.ToSlash(rel)))
+		if err == nil && !allowed {
+			return filepath.SkipAll
+		}
+		return err
+	})
+	return allowed, err
+}
+
+func (w *Workspace) readAccessFile(dir string) ([]AccessRule, error) {
+	accessFilePath := filepath.Join(w.Root, filepath.FromSlash(dir), AccessFileName)
+	data, err := os.ReadFile(accessFilePath)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	access := AccessConfig{}
+	err = json.Unmarshal(data, &access)
+	if err == nil {
+		err = access.validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Access file invalid: %s: %w", dir, err)
+	}
+	return access.Rules, nil
+}
@@ -0,0 +1,115 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+)
+
+type testMakeshiftd struct {
+	workspaces []*Workspace
+}
+
+func (m *testMakeshiftd) Workspaces() []*Workspace {
+	return m.workspaces
+}
+
+func (m *testMakeshiftd) ServeError(cause interface{}, res http.ResponseWriter, req *http.Request) {
+	if code, ok := cause.(int); ok {
+		res.WriteHeader(code)
+		return
+	}
+	res.WriteHeader(http.StatusInternalServerError)
+}
+
+func TestAuthorize(t *testing.T) {
+	root := t.TempDir()
+
+	config := viper.New()
+	config.Set("access", map[string]interface{}{
+		"default": "deny",
+		"rules": []interface{}{
+			map[string]interface{}{
+				"methods":    []string{"GET"},
+				"principals": []string{"*"},
+			},
+			map[string]interface{}{
+				"methods":    []string{"*"},
+				"principals": []string{"group:editors"},
+			},
+		},
+	})
+
+	err := os.MkdirAll(filepath.Join(root, "secret"), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.WriteFile(filepath.Join(root, "secret", AccessFileName), []byte(`{
+		"rules": [
+			{ "paths": ["**"], "principals": ["*"], "effect": "deny" },
+			{ "paths": ["public/*.html"], "methods": ["GET"], "principals": ["*"] }
+		]
+	}`), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	visitor := auth.Principal{Name: auth.Anonymous}
+	editor := auth.Principal{Name: "alice", Groups: []string{"editors"}}
+
+	table := []struct {
+		Principal auth.Principal
+		Method    string
+		Path      string
+		Allowed   bool
+	}{
+		{Principal: visitor, Method: "GET", Path: "/index.html", Allowed: true},
+		{Principal: visitor, Method: "PUT", Path: "/index.html", Allowed: false},
+		{Principal: visitor, Method: AccessExec, Path: "/script", Allowed: false},
+		{Principal: editor, Method: "PUT", Path: "/index.html", Allowed: true},
+		{Principal: editor, Method: AccessExec, Path: "/script", Allowed: true},
+		{Principal: editor, Method: "GET", Path: "/secret/data.json", Allowed: false},
+		{Principal: visitor, Method: "GET", Path: "/secret/public/page.html", Allowed: false},
+	}
+
+	for _, row := range table {
+		t.Run(row.Principal.Name+":"+row.Method+":"+row.Path, func(t *testing.T) {
+			allowed, err := w.authorize(row.Principal, row.Method, row.Path)
+			require.Nil(t, err, err)
+			require.Equal(t, row.Allowed, allowed)
+		})
+	}
+}
+
+func TestAccessInvalidEffect(t *testing.T) {
+	root := t.TempDir()
+
+	// A misspelled effect does not allow the requests
+	for _, access := range []map[string]interface{}{
+		{"default": "deney"},
+		{"default": "deny", "rules": []interface{}{map[string]interface{}{"principals": []string{"*"}, "effect": "Deny "}}},
+	} {
+		config := viper.New()
+		config.Set("access", access)
+		w := New(&testMakeshiftd{}, "test", root, config)
+		require.NotNil(t, w.err)
+	}
+
+	err := os.WriteFile(filepath.Join(root, AccessFileName), []byte(`{"rules": [{"principals": ["*"], "effect": "dney"}]}`), os.ModePerm)
+	require.Nil(t, err, err)
+	w := New(&testMakeshiftd{}, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+	_, err = w.authorize(auth.Principal{Name: auth.Anonymous}, "GET", "/index.html")
+	require.NotNil(t, err)
+
+	req := httptest.NewRequest("GET", "/index.html", nil)
+	res := httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.Equal(t, http.StatusInternalServerError, res.Code)
+}
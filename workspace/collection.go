@@ -0,0 +1,467 @@
+package workspace
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// Query parameters of the collections
+const (
+	WhereQuery  = "where"
+	SortQuery   = "sort"
+	FieldsQuery = "fields"
+	LimitQuery  = "limit"
+	CursorQuery = "cursor"
+)
+
+// IDField is the field of the items of a collection with the name of the document
+const IDField = "_id"
+
+// Limits of the number of items of a page of a collection
+const (
+	defaultCollectionLimit = 100
+	maxCollectionLimit     = 1000
+)
+
+// collections are the indexes of the JSON documents of the directories
+// queried as collections, a document is parsed again if its ETag changes
+// or if it is changed by the server
+type collections struct {
+	mtx  sync.Mutex
+	dirs map[string]map[string]collectionDoc
+}
+
+type collectionDoc struct {
+	etag string
+	item map[string]interface{}
+}
+
+// invalidate removes the document, or the directory, from the indexes
+func (c *collections) invalidate(docPath string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	docPath = strings.Trim(docPath, "/")
+	delete(c.dirs, docPath)
+	dir, name := urlpath.Split(docPath)
+	if docs, ok := c.dirs[strings.Trim(dir, "/")]; ok {
+		delete(docs, name)
+	}
+}
+
+// items returns the items of the JSON object documents of the directory
+func (c *collections) items(dir, dirFilePath string) ([]map[string]interface{}, error) {
+	entries, err := os.ReadDir(dirFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	dir = strings.Trim(dir, "/")
+	if c.dirs == nil {
+		c.dirs = map[string]map[string]collectionDoc{}
+	}
+	cached := c.dirs[dir]
+	docs := map[string]collectionDoc{}
+	items := []map[string]interface{}{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+			!strings.EqualFold(filepath.Ext(name), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		etag := docETag(info)
+		doc, ok := cached[name]
+		if !ok || doc.etag != etag {
+			doc = collectionDoc{etag: etag}
+			data, err := os.ReadFile(filepath.Join(dirFilePath, name))
+			if err != nil {
+				continue
+			}
+			// The documents that are not JSON objects are not items
+			value, err := decodeJSON(data)
+			if object, ok := value.(map[string]interface{}); err == nil && ok {
+				object[IDField] = strings.TrimSuffix(name, filepath.Ext(name))
+				doc.item = object
+			}
+		}
+		docs[name] = doc
+		if doc.item != nil {
+			items = append(items, doc.item)
+		}
+	}
+	c.dirs[dir] = docs
+	return items, nil
+}
+
+// whereClause is a condition of the 'where' query of the form 'field:op:value'
+type whereClause struct {
+	field []string
+	op    string
+	value string
+}
+
+var whereOps = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"contains": true, "prefix": true, "exists": true,
+}
+
+func parseWhere(values []string) ([]whereClause, error) {
+	clauses := []whereClause{}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || !whereOps[parts[1]] {
+			return nil, fmt.Errorf("Where clause invalid: %s", value)
+		}
+		clause := whereClause{field: strings.Split(parts[0], "."), op: parts[1]}
+		if len(parts) == 3 {
+			clause.value = parts[2]
+		} else if clause.op != "exists" {
+			return nil, fmt.Errorf("Where clause value missing: %s", value)
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+// fieldValue returns the value of the dotted field of the item
+func fieldValue(item map[string]interface{}, field []string) (interface{}, bool) {
+	var value interface{} = item
+	for _, name := range field {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// match returns true if the item satisfies the clause, the value of the clause
+// is compared as the type of the value of the field
+func (c whereClause) match(item map[string]interface{}) bool {
+	value, ok := fieldValue(item, c.field)
+	if c.op == "exists" {
+		return ok == (c.value != "false")
+	}
+	if !ok {
+		return c.op == "ne"
+	}
+
+	switch c.op {
+	case "contains":
+		switch v := value.(type) {
+		case string:
+			return strings.Contains(v, c.value)
+		case []interface{}:
+			for _, elem := range v {
+				if cmp, ok := compareWhere(elem, c.value); ok && cmp == 0 {
+					return true
+				}
+			}
+		}
+		return false
+	case "prefix":
+		s, ok := value.(string)
+		return ok && strings.HasPrefix(s, c.value)
+	}
+
+	cmp, ok := compareWhere(value, c.value)
+	if !ok {
+		return c.op == "ne"
+	}
+	switch c.op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	}
+	return false
+}
+
+// compareWhere compares the value to the query value parsed as the type of the value
+func compareWhere(value interface{}, query string) (int, bool) {
+	switch v := value.(type) {
+	case string:
+		return strings.Compare(v, query), true
+	case json.Number:
+		q, err := strconv.ParseFloat(query, 64)
+		if err != nil {
+			return 0, false
+		}
+		return compareValues(v, json.Number(strconv.FormatFloat(q, 'g', -1, 64))), true
+	case bool:
+		q, err := strconv.ParseBool(query)
+		if err != nil {
+			return 0, false
+		}
+		return compareValues(v, q), true
+	case nil:
+		return 0, query == "null"
+	}
+	return 0, false
+}
+
+// compareValues orders the JSON values, the values of different types
+// are ordered as null, booleans, numbers, strings and other values
+func compareValues(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 1
+		case json.Number, float64:
+			return 2
+		case string:
+			return 3
+		}
+		return 4
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+	switch va := a.(type) {
+	case bool:
+		vb := b.(bool)
+		switch {
+		case va == vb:
+			return 0
+		case !va:
+			return -1
+		}
+		return 1
+	case json.Number, float64:
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(va, b.(string))
+	}
+	return 0
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// sortKey is a field of the 'sort' query, prefixed with '-' if descending
+type sortKey struct {
+	field []string
+	desc  bool
+}
+
+func parseSort(value string) []sortKey {
+	keys := []sortKey{}
+	for _, field := range strings.Split(value, ",") {
+		key := sortKey{}
+		if strings.HasPrefix(field, "-") {
+			key.desc = true
+			field = field[1:]
+		}
+		if field != "" && field != IDField {
+			key.field = strings.Split(field, ".")
+			keys = append(keys, key)
+		}
+	}
+	// The ID is the last key so that the order is total
+	return append(keys, sortKey{field: []string{IDField}})
+}
+
+// sortValues returns the values of the sort keys of the item
+func sortValues(item map[string]interface{}, keys []sortKey) []interface{} {
+	values := make([]interface{}, len(keys))
+	for idx, key := range keys {
+		values[idx], _ = fieldValue(item, key.field)
+	}
+	return values
+}
+
+func compareSortValues(a, b []interface{}, keys []sortKey) int {
+	for idx, key := range keys {
+		if idx >= len(a) || idx >= len(b) {
+			break
+		}
+		cmp := compareValues(a[idx], b[idx])
+		if key.desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// encodeCursor returns the cursor after the item with the sort values
+func encodeCursor(values []interface{}) string {
+	data, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("Cursor invalid")
+	}
+	value, err := decodeJSON(data)
+	values, ok := value.([]interface{})
+	if err != nil || !ok {
+		return nil, fmt.Errorf("Cursor invalid")
+	}
+	return values, nil
+}
+
+// serveCollection serves the JSON documents of the directory as an array of
+// items filtered by the 'where' query, ordered by the 'sort' query, and with
+// the fields of the 'fields' query, the next page is linked with a cursor
+func (w *Workspace) serveCollection(docPath string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	query := req.URL.Query()
+	badRequest := func(err error) {
+		res.WriteHeader(http.StatusBadRequest)
+		res.Write([]byte(err.Error()))
+	}
+
+	clauses, err := parseWhere(query[WhereQuery])
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	keys := parseSort(query.Get(SortQuery))
+	limit := defaultCollectionLimit
+	if value := query.Get(LimitQuery); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			badRequest(fmt.Errorf("Limit invalid: %s", value))
+			return
+		}
+		if limit > maxCollectionLimit {
+			limit = maxCollectionLimit
+		}
+	}
+	var after []interface{}
+	if cursor := query.Get(CursorQuery); cursor != "" {
+		if after, err = decodeCursor(cursor); err != nil {
+			badRequest(err)
+			return
+		}
+	}
+
+	dirFilePath := filepath.Join(w.Root, filepath.FromSlash(docPath))
+	items, err := w.collections.items(docPath, dirFilePath)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+
+	principal := auth.Ctx(req.Context())
+	matches := []map[string]interface{}{}
+	for _, item := range items {
+		visible := true
+		for _, clause := range clauses {
+			if !clause.match(item) {
+				visible = false
+				break
+			}
+		}
+		if !visible {
+			continue
+		}
+		allowed, err := w.authorize(principal, "GET", urlpath.Join(docPath, item[IDField].(string)+".json"))
+		if err != nil {
+			log.Warn().Err(err).Msg("Access rules evaluation failed")
+			w.serveError(err, res, req)
+			return
+		}
+		if allowed {
+			matches = append(matches, item)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return compareSortValues(sortValues(matches[i], keys), sortValues(matches[j], keys), keys) < 0
+	})
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matches), func(i int) bool {
+			return compareSortValues(sortValues(matches[i], keys), after, keys) > 0
+		})
+	}
+	end := start + limit
+	if end > len(matches) {
+		end = len(matches)
+	}
+	page := matches[start:end]
+
+	fields := []string{}
+	if value := query.Get(FieldsQuery); value != "" {
+		fields = append(strings.Split(value, ","), IDField)
+	}
+	result := make([]map[string]interface{}, len(page))
+	for idx, item := range page {
+		if len(fields) == 0 {
+			result[idx] = item
+			continue
+		}
+		projected := map[string]interface{}{}
+		for _, field := range fields {
+			if value, ok := item[field]; ok {
+				projected[field] = value
+			}
+		}
+		result[idx] = projected
+	}
+
+	res.Header().Set("X-Total-Count", strconv.Itoa(len(matches)))
+	if end < len(matches) {
+		next := url.Values{}
+		for name, values := range query {
+			next[name] = values
+		}
+		next.Set(CursorQuery, encodeCursor(sortValues(page[len(page)-1], keys)))
+		res.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, urlpath.Join("/", w.Slug, docPath), next.Encode()))
+	}
+	w.serveJSON(result, res, req)
+}
@@ -0,0 +1,134 @@
+package workspace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollection(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"tasks/a.json":    `{"status":"open","priority":2,"tags":["x"]}`,
+		"tasks/b.json":    `{"status":"closed","priority":10}`,
+		"tasks/c.json":    `{"status":"open","priority":3,"owner":{"name":"ann"}}`,
+		"tasks/list.json": `[1,2]`,
+		"tasks/note.txt":  `text`,
+		"tasks/.hidden":   `{}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		require.Nil(t, err, err)
+		err = os.WriteFile(path, []byte(content), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+
+	config := viper.New()
+	config.Set("collections.enabled", true)
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		return res
+	}
+	ids := func(t *testing.T, res *httptest.ResponseRecorder) string {
+		items := []map[string]interface{}{}
+		err := json.Unmarshal(res.Body.Bytes(), &items)
+		require.Nil(t, err, err)
+		ids := []string{}
+		for _, item := range items {
+			ids = append(ids, item[IDField].(string))
+		}
+		return strings.Join(ids, ",")
+	}
+
+	table := []struct {
+		Path string
+		Code int
+		IDs  string
+	}{
+		{Path: "/tasks", Code: http.StatusOK, IDs: "a,b,c"},
+		{Path: "/tasks?where=status:eq:open", Code: http.StatusOK, IDs: "a,c"},
+		{Path: "/tasks?where=priority:gt:2", Code: http.StatusOK, IDs: "b,c"},
+		{Path: "/tasks?where=status:eq:open&where=priority:le:2", Code: http.StatusOK, IDs: "a"},
+		{Path: "/tasks?where=tags:contains:x", Code: http.StatusOK, IDs: "a"},
+		{Path: "/tasks?where=owner.name:eq:ann", Code: http.StatusOK, IDs: "c"},
+		{Path: "/tasks?where=owner:exists", Code: http.StatusOK, IDs: "c"},
+		{Path: "/tasks?sort=-priority", Code: http.StatusOK, IDs: "b,c,a"},
+		{Path: "/tasks?sort=status,-priority", Code: http.StatusOK, IDs: "b,c,a"},
+		{Path: "/tasks?where=status", Code: http.StatusBadRequest},
+		{Path: "/tasks?where=status:like:x", Code: http.StatusBadRequest},
+		{Path: "/tasks?limit=0", Code: http.StatusBadRequest},
+		{Path: "/tasks?cursor=x", Code: http.StatusBadRequest},
+	}
+
+	for _, row := range table {
+		t.Run(row.Path, func(t *testing.T) {
+			res := get(t, row.Path)
+			require.Equal(t, row.Code, res.Code, res.Body.String())
+			if row.Code == http.StatusOK {
+				require.Equal(t, "application/json", res.Header().Get("Content-Type"))
+				require.Equal(t, row.IDs, ids(t, res))
+			}
+		})
+	}
+
+	t.Run("Fields", func(t *testing.T) {
+		res := get(t, "/tasks?fields=status&where=priority:eq:10")
+		require.Equal(t, http.StatusOK, res.Code)
+		require.JSONEq(t, `[{"_id":"b","status":"closed"}]`, res.Body.String())
+	})
+
+	t.Run("Cursor", func(t *testing.T) {
+		seen := []string{}
+		path := "/tasks?sort=-priority&limit=2"
+		for path != "" {
+			res := get(t, path)
+			require.Equal(t, http.StatusOK, res.Code)
+			require.Equal(t, "3", res.Header().Get("X-Total-Count"))
+			seen = append(seen, ids(t, res))
+			path = ""
+			if link := res.Header().Get("Link"); link != "" {
+				require.True(t, strings.HasSuffix(link, `>; rel="next"`), link)
+				path = strings.TrimPrefix(link[1:strings.Index(link, ">")], "/test")
+			}
+		}
+		require.Equal(t, []string{"b,c", "a"}, seen)
+	})
+
+	t.Run("Fresh", func(t *testing.T) {
+		require.Equal(t, "a,b,c", ids(t, get(t, "/tasks")))
+
+		req := httptest.NewRequest("PUT", "/tasks/a.json", strings.NewReader(`{"status":"done","priority":2,"tags":["x"]}`))
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		req = httptest.NewRequest("PUT", "/tasks/d.json", strings.NewReader(`{"status":"open"}`))
+		res = httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		require.Equal(t, http.StatusCreated, res.Code)
+
+		require.Equal(t, "c,d", ids(t, get(t, "/tasks?where=status:eq:open")))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		w := New(&testMakeshiftd{}, "other", root, nil)
+		require.Nil(t, w.err, w.err)
+		req := httptest.NewRequest("GET", "/tasks", nil)
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		require.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
@@ -0,0 +1,315 @@
+package workspace
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/context"
+	"github.com/makeshiftd/makeshiftd/metrics"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+type allowedSlugsKey struct{}
+
+// WithAllowedSlugs returns a copy of the context restricted to the workspaces
+// with the slugs, the destination of a copy or move must be one of them
+func WithAllowedSlugs(ctx context.C, slugs map[string]bool) context.C {
+	return context.WithValue(ctx, allowedSlugsKey{}, slugs)
+}
+
+// slugAllowed returns true if the workspace with the slug is allowed
+// by the context, all workspaces are allowed if not restricted
+func slugAllowed(ctx context.C, slug string) bool {
+	slugs, ok := ctx.Value(allowedSlugsKey{}).(map[string]bool)
+	return !ok || slugs[slug]
+}
+
+// destination returns the workspace and the document path of the Destination
+// header of a copy or move, the workspace may be another workspace of the
+// service allowed for the request, returns the status code of the error if
+// the destination is invalid
+func (w *Workspace) destination(req *http.Request) (*Workspace, string, int) {
+	value := req.Header.Get("Destination")
+	if value == "" {
+		return nil, "", http.StatusBadRequest
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, "", http.StatusBadRequest
+	}
+	if u.Host != "" && !strings.EqualFold(u.Host, req.Host) {
+		return nil, "", http.StatusBadGateway
+	}
+
+	slug, path := urlpath.PopLeft(u.Path)
+	slug = strings.ToLower(slug)
+	var dest *Workspace
+	if slug == w.Slug {
+		dest = w
+	} else {
+		for _, workspace := range w.m.Workspaces() {
+			if slug == workspace.Slug {
+				dest = workspace
+			}
+		}
+	}
+	if dest == nil || !slugAllowed(req.Context(), dest.Slug) {
+		return nil, "", http.StatusBadGateway
+	}
+
+	segments, exec, ok := docSegments(path)
+	if !ok || exec || len(segments) == 0 {
+		return nil, "", http.StatusForbidden
+	}
+	return dest, urlpath.Join(segments...), 0
+}
+
+// serveDocCopy copies or moves the document or directory to the Destination,
+// an existing destination is replaced unless the Overwrite header is 'F'
+func (w *Workspace) serveDocCopy(docPath string, move bool, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	if docPath == "" {
+		w.serveError(http.StatusForbidden, res, req)
+		return
+	}
+
+	dest, destPath, code := w.destination(req)
+	if code != 0 {
+		log.Debug().Msgf("Destination invalid: %s", req.Header.Get("Destination"))
+		w.serveError(code, res, req)
+		return
+	}
+
+	principal := auth.Ctx(req.Context())
+	allowed, err := dest.authorize(principal, "PUT", destPath)
+	if err != nil {
+		log.Warn().Err(err).Msg("Access rules evaluation failed")
+		w.serveError(err, res, req)
+		return
+	}
+	if !allowed {
+		log.Debug().Msgf("Access denied: %s PUT %s/%s", principal.Name, dest.Slug, destPath)
+		w.serveError(http.StatusForbidden, res, req)
+		return
+	}
+
+	srcFilePath := filepath.Join(w.Root, filepath.FromSlash(docPath))
+	destFilePath := filepath.Join(dest.Root, filepath.FromSlash(destPath))
+	log.Debug().Msgf("Copy file path: %s, destination: %s, move: %t", srcFilePath, destFilePath, move)
+
+	if srcFilePath == destFilePath {
+		w.serveError(http.StatusForbidden, res, req)
+		return
+	}
+	if strings.HasPrefix(destFilePath, srcFilePath+string(filepath.Separator)) {
+		w.serveError(http.StatusConflict, res, req)
+		return
+	}
+
+	// The documents are not modified in place concurrently
+	unlock := lockFiles(srcFilePath, destFilePath)
+	defer unlock()
+
+	srcInfo, err := os.Stat(srcFilePath)
+	if os.IsNotExist(err) {
+		w.serveError(http.StatusNotFound, res, req)
+		return
+	}
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+
+	if !w.authorizeCopy(docPath, srcFilePath, srcInfo, dest, destPath, res, req) {
+		return
+	}
+
+	destInfo, err := os.Stat(destFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		w.serveError(err, res, req)
+		return
+	}
+	if err != nil {
+		destInfo = nil
+	}
+	if destInfo != nil && strings.EqualFold(req.Header.Get("Overwrite"), "F") {
+		w.serveError(http.StatusPreconditionFailed, res, req)
+		return
+	}
+
+	srcBytes, srcFiles, err := treeUsage(srcFilePath)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	var destBytes, destFiles int64
+	if destInfo != nil {
+		destBytes, destFiles, err = treeUsage(destFilePath)
+		if err != nil {
+			w.serveError(err, res, req)
+			return
+		}
+	}
+	err = dest.validateTree(srcFilePath, srcInfo, destPath)
+	if err != nil {
+		log.Debug().Err(err).Msgf("Copy rejected: %s", destFilePath)
+		w.serveWriteError(err, res, req)
+		return
+	}
+
+	// The usage of the destination is added before the copy so that
+	// concurrent writes cannot together exceed the quota
+	reserved := !move || dest != w
+	if reserved {
+		err = dest.addUsage(srcBytes-destBytes, srcFiles-destFiles)
+		if err != nil {
+			log.Debug().Err(err).Msgf("Copy rejected: %s", destFilePath)
+			w.serveWriteError(err, res, req)
+			return
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(destFilePath), os.ModePerm)
+	if err == nil {
+		if move {
+			err = moveTree(srcFilePath, destFilePath, srcInfo, destInfo)
+		} else {
+			err = copyTree(srcFilePath, destFilePath, srcInfo, destInfo)
+		}
+	}
+	if err != nil {
+		log.Err(err).Msgf("Error copying file: %s", srcFilePath)
+		// The usage is uncertain after a partial copy
+		dest.reconcileUsage()
+		w.reconcileUsage()
+		w.serveError(err, res, req)
+		return
+	}
+
+	if !reserved {
+		dest.updateUsage(srcBytes-destBytes, srcFiles-destFiles)
+	}
+	if move {
+		w.updateUsage(-srcBytes, -srcFiles)
+	}
+	if reserved {
+		metrics.WrittenBytes.WithLabelValues(dest.Slug).Add(float64(srcBytes))
+	}
+	if move && dest == w {
+		w.record(req.Context(), req.Method, docPath, destPath)
+	} else {
+		if move {
+			w.record(req.Context(), req.Method, docPath)
+		}
+		dest.record(req.Context(), req.Method, destPath)
+	}
+	if move {
+		w.notify(EventDelete, docPath)
+	}
+	if destInfo != nil {
+		dest.notify(EventModify, destPath)
+	} else {
+		dest.notify(EventCreate, destPath)
+	}
+
+	if destInfo != nil {
+		res.WriteHeader(http.StatusNoContent)
+	} else {
+		res.WriteHeader(http.StatusCreated)
+	}
+}
+
+// authorizeCopy authorizes the copy or move of each of the files of the tree
+// at the source path, the files are read, or deleted if moved, at the source
+// and written at the destination, the error is served if not authorized
+func (w *Workspace) authorizeCopy(docPath, srcFilePath string, srcInfo os.FileInfo, dest *Workspace, destPath string, res http.ResponseWriter, req *http.Request) bool {
+	log := log.Ctx(req.Context())
+
+	principal := auth.Ctx(req.Context())
+	method := accessMethod(req.Method, false)
+	allowed, err := w.authorizeTree(principal, method, srcFilePath, srcInfo, docPath)
+	if err == nil && allowed {
+		method = "PUT"
+		allowed, err = dest.authorizeTree(principal, method, srcFilePath, srcInfo, destPath)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Access rules evaluation failed")
+		w.serveError(err, res, req)
+		return false
+	}
+	if !allowed {
+		log.Debug().Msgf("Access denied: %s %s of the tree: %s", principal.Name, method, srcFilePath)
+		w.serveError(http.StatusForbidden, res, req)
+		return false
+	}
+	return true
+}
+
+// moveTree moves the file tree at the source path to the destination path,
+// the rename is atomic unless the destination is a directory being replaced
+// or the destination is on another device
+func moveTree(srcPath, destPath string, srcInfo, destInfo os.FileInfo) error {
+	if destInfo != nil && (destInfo.IsDir() || srcInfo.IsDir()) {
+		if err := os.RemoveAll(destPath); err != nil {
+			return err
+		}
+		destInfo = nil
+	}
+	err := os.Rename(srcPath, destPath)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyTree(srcPath, destPath, srcInfo, destInfo)
+		if err == nil {
+			err = os.RemoveAll(srcPath)
+		}
+	}
+	return err
+}
+
+// copyTree copies the file tree at the source path to the destination path,
+// a file replacing a file is replaced only if copied completely
+func copyTree(srcPath, destPath string, srcInfo, destInfo os.FileInfo) error {
+	if destInfo != nil && (destInfo.IsDir() || srcInfo.IsDir()) {
+		if err := os.RemoveAll(destPath); err != nil {
+			return err
+		}
+		destInfo = nil
+	}
+	if !srcInfo.IsDir() {
+		return copyFile(srcPath, destPath, destInfo)
+	}
+	return filepath.WalkDir(srcPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destPath, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, os.ModePerm)
+		case d.Type().IsRegular():
+			return copyFile(path, target, nil)
+		}
+		return nil
+	})
+}
+
+func copyFile(srcPath, destPath string, destInfo os.FileInfo) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	_, err = writeDocFile(destPath, destInfo, srcFile)
+	return err
+}
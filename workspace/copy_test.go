@@ -0,0 +1,166 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopy(t *testing.T) {
+	root := t.TempDir()
+	otherRoot := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(root, "dir"), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.MkdirAll(filepath.Join(root, "acl"), os.ModePerm)
+	require.Nil(t, err, err)
+	for _, name := range []string{"a.txt", "dir/x.txt", "dir/y.txt", "acl/z.txt"} {
+		err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(name), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+	// The access file of a copied directory would replace the access file of the destination
+	err = os.WriteFile(filepath.Join(root, "acl", AccessFileName), []byte(`{"rules": [{"paths": ["**"], "principals": ["*"], "effect": "allow"}]}`), os.ModePerm)
+	require.Nil(t, err, err)
+
+	m := &testMakeshiftd{}
+	w := New(m, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+	other := New(m, "other", otherRoot, nil)
+	require.Nil(t, other.err, other.err)
+	m.workspaces = []*Workspace{w, other}
+
+	table := []struct {
+		Name        string
+		Method      string
+		Path        string
+		Destination string
+		Overwrite   string
+		Code        int
+		Exists      []string
+		NotExists   []string
+	}{
+		{Name: "Copy", Method: "COPY", Path: "/a.txt", Destination: "/test/b.txt", Code: http.StatusCreated, Exists: []string{"a.txt", "b.txt"}},
+		{Name: "NoOverwrite", Method: "COPY", Path: "/a.txt", Destination: "/test/b.txt", Overwrite: "F", Code: http.StatusPreconditionFailed},
+		{Name: "Overwrite", Method: "COPY", Path: "/dir/x.txt", Destination: "/test/b.txt", Code: http.StatusNoContent},
+		{Name: "MoveDir", Method: "MOVE", Path: "/dir", Destination: "http://example.com/test/moved", Code: http.StatusCreated, Exists: []string{"moved/x.txt"}, NotExists: []string{"dir"}},
+		{Name: "CopyOther", Method: "COPY", Path: "/moved", Destination: "/other/copied", Code: http.StatusCreated, Exists: []string{"moved/y.txt"}},
+		{Name: "Hidden", Method: "MOVE", Path: "/a.txt", Destination: "/test/_a.txt", Code: http.StatusForbidden, Exists: []string{"a.txt"}},
+		{Name: "IntoItself", Method: "COPY", Path: "/moved", Destination: "/test/moved/sub", Code: http.StatusConflict},
+		{Name: "OtherHost", Method: "COPY", Path: "/a.txt", Destination: "http://other.example.com/test/c.txt", Code: http.StatusBadGateway},
+		{Name: "NotFound", Method: "MOVE", Path: "/missing.txt", Destination: "/test/c.txt", Code: http.StatusNotFound},
+		{Name: "CopyHiddenTree", Method: "COPY", Path: "/acl", Destination: "/test/moved", Code: http.StatusForbidden, Exists: []string{"moved/x.txt"}, NotExists: []string{"moved/" + AccessFileName}},
+		{Name: "MoveHiddenTree", Method: "MOVE", Path: "/acl", Destination: "/test/aclmoved", Code: http.StatusForbidden, Exists: []string{"acl/" + AccessFileName}, NotExists: []string{"aclmoved"}},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			req := httptest.NewRequest(row.Method, row.Path, nil)
+			req.Header.Set("Destination", row.Destination)
+			if row.Overwrite != "" {
+				req.Header.Set("Overwrite", row.Overwrite)
+			}
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code)
+			for _, name := range row.Exists {
+				_, err := os.Stat(filepath.Join(root, filepath.FromSlash(name)))
+				require.Nil(t, err, err)
+			}
+			for _, name := range row.NotExists {
+				_, err := os.Stat(filepath.Join(root, filepath.FromSlash(name)))
+				require.True(t, os.IsNotExist(err))
+			}
+		})
+	}
+
+	t.Run("NotAllowed", func(t *testing.T) {
+		// The destination workspace must be allowed by the listener
+		req := httptest.NewRequest("COPY", "/a.txt", nil)
+		req = req.WithContext(WithAllowedSlugs(req.Context(), map[string]bool{"test": true}))
+		req.Header.Set("Destination", "/other/hidden.txt")
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		require.Equal(t, http.StatusBadGateway, res.Code)
+		_, err := os.Stat(filepath.Join(otherRoot, "hidden.txt"))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	data, err := os.ReadFile(filepath.Join(root, "b.txt"))
+	require.Nil(t, err, err)
+	require.Equal(t, "dir/x.txt", string(data))
+
+	data, err = os.ReadFile(filepath.Join(otherRoot, "copied", "y.txt"))
+	require.Nil(t, err, err)
+	require.Equal(t, "dir/y.txt", string(data))
+
+	usage, otherUsage := w.Usage(), other.Usage()
+	require.Nil(t, w.reconcileUsage())
+	require.Nil(t, other.reconcileUsage())
+	require.Equal(t, w.Usage(), usage)
+	require.Equal(t, other.Usage(), otherUsage)
+}
+
+func TestCopyAccess(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"docs/public.txt":                 "public",
+		"docs/private/secret.txt":         "secret",
+		"docs/private/" + AccessFileName:  `{"rules": [{"paths": ["**"], "methods": ["GET", "DELETE"], "principals": ["*"], "effect": "deny"}]}`,
+		"keys/a.key":                      "key",
+		"webdav/target/" + AccessFileName: `{"rules": [{"paths": ["**/*.key"], "methods": ["PUT"], "principals": ["*"], "effect": "deny"}]}`,
+		"test/target/" + AccessFileName:   `{"rules": [{"paths": ["**/*.key"], "methods": ["PUT"], "principals": ["*"], "effect": "deny"}]}`,
+	}
+	for name, data := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(root, filepath.FromSlash(name))), os.ModePerm)
+		require.Nil(t, err, err)
+		err = os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(data), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+
+	m := &testMakeshiftd{}
+	w := New(m, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+	webdavConfig := viper.New()
+	webdavConfig.Set("webdav.enabled", true)
+	dav := New(m, "webdav", root, webdavConfig)
+	require.Nil(t, dav.err, dav.err)
+	m.workspaces = []*Workspace{w, dav}
+
+	table := []struct {
+		Name        string
+		Method      string
+		Path        string
+		Destination string
+		Code        int
+		NotExists   []string
+	}{
+		{Name: "CopyRestricted", Method: "COPY", Path: "/docs", Destination: "/copied", Code: http.StatusForbidden, NotExists: []string{"copied"}},
+		{Name: "MoveRestricted", Method: "MOVE", Path: "/docs", Destination: "/moved", Code: http.StatusForbidden, NotExists: []string{"moved"}},
+		{Name: "CopyToRestricted", Method: "COPY", Path: "/keys", Destination: "/target/keys", Code: http.StatusForbidden, NotExists: []string{"target/keys"}},
+		{Name: "CopyPublic", Method: "COPY", Path: "/docs/public.txt", Destination: "/public.txt", Code: http.StatusCreated},
+	}
+
+	for _, workspace := range m.workspaces {
+		for _, row := range table {
+			t.Run(workspace.Slug+":"+row.Name, func(t *testing.T) {
+				req := httptest.NewRequest(row.Method, row.Path, nil)
+				// The destinations of each workspace are in a directory named by the slug
+				req.Header.Set("Destination", "/"+workspace.Slug+"/"+workspace.Slug+row.Destination)
+				req.Header.Set("Overwrite", "T")
+				res := httptest.NewRecorder()
+				workspace.ServeHTTP(res, req)
+				require.Equal(t, row.Code, res.Code)
+				for _, name := range row.NotExists {
+					_, err := os.Stat(filepath.Join(root, workspace.Slug, filepath.FromSlash(name)))
+					require.True(t, os.IsNotExist(err))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/websocket"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// EventsAPI is the segment of the workspace API streaming the change events
+const EventsAPI = "_events"
+
+// Types of change events
+const (
+	EventCreate = "create"
+	EventModify = "modify"
+	EventDelete = "delete"
+)
+
+// eventHistorySize is the number of recent events kept to resume streams
+const eventHistorySize = 1024
+
+// eventBufferSize is the number of events buffered for each subscriber,
+// a subscriber that falls further behind is closed and must resume
+const eventBufferSize = 64
+
+// eventKeepAlive is the interval of the keep alive comments of event streams
+const eventKeepAlive = 30 * time.Second
+
+// Event is a change of a document of the workspace
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Path string    `json:"path"`
+	ETag string    `json:"etag,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// events are the change events of the workspace, the events of the file
+// system watcher and of the write paths of the server are deduplicated
+// by the ETag of the document
+type events struct {
+	mtx         sync.Mutex
+	nextID      uint64
+	history     []Event
+	etags       map[string]string
+	subscribers map[chan Event]bool
+	watchOnce   sync.Once
+}
+
+// docETag returns the ETag of the document file
+func docETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// notify publishes the change of the document by the server
+func (w *Workspace) notify(eventType, docPath string) {
+	docPath = urlpath.Join("/", docPath)
+	etag := ""
+	if eventType != EventDelete {
+		info, err := os.Stat(filepath.Join(w.Root, filepath.FromSlash(docPath)))
+		if err != nil {
+			return
+		}
+		etag = docETag(info)
+	}
+	w.publish(eventType, docPath, etag)
+}
+
+func (w *Workspace) publish(eventType, docPath, etag string) {
+	if w.collections != nil {
+		w.collections.invalidate(docPath)
+	}
+	w.indexChanged(docPath)
+
+	w.events.mtx.Lock()
+	defer w.events.mtx.Unlock()
+
+	if w.events.etags == nil {
+		w.events.etags = map[string]string{}
+	}
+	last, ok := w.events.etags[docPath]
+	if ok && last == etag {
+		return
+	}
+	w.events.etags[docPath] = etag
+
+	// The file system reports the atomic replacement of a document as a create
+	if eventType == EventCreate && ok && last != "" {
+		eventType = EventModify
+	}
+
+	w.events.nextID++
+	e := Event{
+		ID:   w.events.nextID,
+		Type: eventType,
+		Path: docPath,
+		ETag: etag,
+		Time: time.Now().UTC(),
+	}
+	w.events.history = append(w.events.history, e)
+	if len(w.events.history) > eventHistorySize {
+		w.events.history = w.events.history[len(w.events.history)-eventHistorySize:]
+	}
+	for ch := range w.events.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(w.events.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of the events after the last event ID and
+// the recent events to replay, the file system watcher is started on the
+// first subscription
+func (w *Workspace) subscribe(lastID uint64) (chan Event, []Event, func()) {
+	w.events.watchOnce.Do(func() {
+		go w.watch()
+	})
+
+	w.events.mtx.Lock()
+	defer w.events.mtx.Unlock()
+
+	replay := []Event{}
+	if lastID > 0 {
+		for _, e := range w.events.history {
+			if e.ID > lastID {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, eventBufferSize)
+	if w.events.subscribers == nil {
+		w.events.subscribers = map[chan Event]bool{}
+	}
+	w.events.subscribers[ch] = true
+
+	return ch, replay, func() {
+		w.events.mtx.Lock()
+		defer w.events.mtx.Unlock()
+		if w.events.subscribers[ch] {
+			delete(w.events.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// watch publishes the changes of the file system until the workspace is cancelled
+func (w *Workspace) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warn().Err(err).Msgf("Workspace watcher failed: %s", w.Slug)
+		return
+	}
+	defer watcher.Close()
+
+	addTree := func(root string) {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if path != w.Root && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+				return filepath.SkipDir
+			}
+			if err := watcher.Add(path); err != nil {
+				log.Warn().Err(err).Msgf("Workspace watcher failed: %s: %s", w.Slug, path)
+			}
+			return nil
+		})
+	}
+	addTree(w.Root)
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn().Err(err).Msgf("Workspace watcher error: %s", w.Slug)
+
+		case fsEvent, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			rel, err := filepath.Rel(w.Root, fsEvent.Name)
+			if err != nil {
+				continue
+			}
+			segments, exec, valid := docSegments(filepath.ToSlash(rel))
+			if !valid || exec || len(segments) == 0 {
+				continue
+			}
+			docPath := urlpath.Join(append([]string{"/"}, segments...)...)
+
+			switch {
+			case fsEvent.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				w.publish(EventDelete, docPath, "")
+			case fsEvent.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				info, err := os.Stat(fsEvent.Name)
+				if err != nil {
+					continue
+				}
+				if info.IsDir() {
+					addTree(fsEvent.Name)
+				}
+				eventType := EventModify
+				if fsEvent.Op&fsnotify.Create != 0 {
+					eventType = EventCreate
+				}
+				w.publish(eventType, docPath, docETag(info))
+			}
+		}
+	}
+}
+
+// serveEvents streams the change events of the documents at the path of the
+// 'path' query, as Server-Sent Events or as WebSocket messages if upgraded,
+// the stream resumes after the event ID of the Last-Event-ID header
+func (w *Workspace) serveEvents(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "OPTIONS":
+		res.Header().Set("Allow", "GET, OPTIONS")
+		res.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		res.Header().Set("Allow", "GET, OPTIONS")
+		w.serveError(http.StatusMethodNotAllowed, res, req)
+		return
+	}
+
+	query := req.URL.Query()
+	segments, exec, ok := docSegments(query.Get("path"))
+	if !ok || exec {
+		w.serveError(http.StatusBadRequest, res, req)
+		return
+	}
+	prefix := urlpath.Join(append([]string{"/"}, segments...)...)
+
+	lastEventID := req.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("lastEventId")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+	principal := auth.Ctx(req.Context())
+	visible := func(e Event) bool {
+		if prefix != "/" && e.Path != prefix && !strings.HasPrefix(e.Path, prefix+"/") {
+			return false
+		}
+		allowed, err := w.authorize(principal, "GET", e.Path)
+		return err == nil && allowed
+	}
+
+	if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		server := websocket.Server{
+			Handshake: w.checkEventsOrigin,
+			Handler: func(conn *websocket.Conn) {
+				w.streamEvents(conn, req, lastID, visible, func(e Event) error {
+					if e.ID == 0 {
+						return nil
+					}
+					return websocket.JSON.Send(conn, e)
+				})
+			},
+		}
+		server.ServeHTTP(res, req)
+		return
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		w.serveError(http.StatusNotImplemented, res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	w.streamEvents(nil, req, lastID, visible, func(e Event) error {
+		var data []byte
+		var err error
+		if e.ID > 0 {
+			data, err = json.Marshal(e)
+			if err == nil {
+				_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			}
+		} else {
+			_, err = io.WriteString(res, ": keepalive\n\n")
+		}
+		flusher.Flush()
+		return err
+	})
+}
+
+// streamEvents sends the visible events until the request or the workspace
+// is done, an event without an ID is sent to keep the stream alive
+func (w *Workspace) streamEvents(conn io.Reader, req *http.Request, lastID uint64, visible func(Event) bool, send func(Event) error) {
+	log := log.Ctx(req.Context())
+
+	events, replay, unsubscribe := w.subscribe(lastID)
+	defer unsubscribe()
+
+	// The connection is closed if the client closes the WebSocket
+	closed := make(chan struct{})
+	if conn != nil {
+		go func() {
+			io.Copy(io.Discard, conn)
+			close(closed)
+		}()
+	}
+
+	for _, e := range replay {
+		if visible(e) {
+			if err := send(e); err != nil {
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				log.Debug().Msg("Event stream closed, subscriber too slow")
+				return
+			}
+			if visible(e) {
+				if err := send(e); err != nil {
+					return
+				}
+			}
+		case <-ticker.C:
+			if err := send(Event{}); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-req.Context().Done():
+			return
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkEventsOrigin checks that the origin of the WebSocket is allowed
+// by the CORS policy of the workspace or is the same as the host
+func (w *Workspace) checkEventsOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if w.cors != nil && w.cors.AllowsOrigin(origin) {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, req.Host) {
+		return nil
+	}
+	return fmt.Errorf("WebSocket origin not allowed: %s", origin)
+}
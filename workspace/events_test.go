@@ -0,0 +1,134 @@
+package workspace
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+func TestEvents(t *testing.T) {
+	root := t.TempDir()
+	err := os.MkdirAll(filepath.Join(root, "data"), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, req.URL.Path = urlpath.PopLeft(req.URL.Path)
+		w.ServeHTTP(res, req)
+	}))
+	defer func() {
+		w.Cancel()
+		server.Close()
+	}()
+
+	put := func(path, body string) {
+		req, err := http.NewRequest("PUT", server.URL+"/test"+path, strings.NewReader(body))
+		require.Nil(t, err, err)
+		res, err := http.DefaultClient.Do(req)
+		require.Nil(t, err, err)
+		res.Body.Close()
+		require.Less(t, res.StatusCode, 300, res.Status)
+	}
+
+	// readEvents returns the events of the SSE stream, the stream is read until closed
+	readEvents := func(res *http.Response) chan Event {
+		events := make(chan Event, 16)
+		go func() {
+			defer close(events)
+			scanner := bufio.NewScanner(res.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.HasPrefix(line, "data: ") {
+					e := Event{}
+					if json.Unmarshal([]byte(line[6:]), &e) == nil {
+						events <- e
+					}
+				}
+			}
+		}()
+		return events
+	}
+	next := func(events chan Event) Event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "Event not received")
+		}
+		return Event{}
+	}
+
+	req, err := http.NewRequest("GET", server.URL+"/test/"+EventsAPI+"?path=/data", nil)
+	require.Nil(t, err, err)
+	res, err := http.DefaultClient.Do(req)
+	require.Nil(t, err, err)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	events := readEvents(res)
+
+	put("/other.json", "{}")
+	put("/data/a.json", `{"a":1}`)
+	e := next(events)
+	require.Equal(t, EventCreate, e.Type)
+	require.Equal(t, "/data/a.json", e.Path)
+	require.NotEmpty(t, e.ETag)
+	firstID := e.ID
+
+	// The ETag changes with the size even if the modification time is coarse
+	put("/data/a.json", `{"a":22}`)
+	e = next(events)
+	require.Equal(t, EventModify, e.Type)
+	require.Equal(t, "/data/a.json", e.Path)
+
+	// Changes made outside of the server are observed by the watcher
+	deadline := time.Now().Add(5 * time.Second)
+	for e.Path != "/data/b.json" && time.Now().Before(deadline) {
+		err = os.WriteFile(filepath.Join(root, "data", "b.json"), []byte(time.Now().String()), os.ModePerm)
+		require.Nil(t, err, err)
+		select {
+		case e = <-events:
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	require.Equal(t, "/data/b.json", e.Path)
+
+	// The stream resumes after the last event ID
+	req, err = http.NewRequest("GET", server.URL+"/test/"+EventsAPI+"?path=/data", nil)
+	require.Nil(t, err, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resumed, err := http.DefaultClient.Do(req)
+	require.Nil(t, err, err)
+	defer resumed.Body.Close()
+	e = next(readEvents(resumed))
+	require.Equal(t, firstID, e.ID)
+
+	// The events are sent as messages of a WebSocket
+	conn, err := websocket.Dial(strings.Replace(server.URL, "http", "ws", 1)+"/test/"+EventsAPI, "", server.URL)
+	require.Nil(t, err, err)
+	defer conn.Close()
+	os.Remove(filepath.Join(root, "data", "b.json"))
+	deadline = time.Now().Add(5 * time.Second)
+	conn.SetReadDeadline(deadline)
+	err = websocket.JSON.Receive(conn, &e)
+	require.Nil(t, err, err)
+	require.Equal(t, EventDelete, e.Type)
+	require.Equal(t, "/data/b.json", e.Path)
+
+	// The streams end when the workspace is cancelled
+	w.Cancel()
+	for range events {
+	}
+	res.Body.Close()
+}
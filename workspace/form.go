@@ -0,0 +1,196 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// RedirectField is the form field with the path to redirect to after the form is posted
+const RedirectField = "_redirect"
+
+// maxFormFieldsSize is the maximum size of the ordinary fields of a multipart form
+const maxFormFieldsSize = 10 << 20
+
+var errFormInvalid = errors.New("Form invalid")
+
+var errFormDenied = errors.New("Form file denied")
+
+// serveDocPostForm stores a posted form, the files of the form are stored as
+// documents in the directory and the ordinary fields are stored as a JSON
+// document with the name, the JSON document refers to the stored files
+func (w *Workspace) serveDocPostForm(docDir, docName string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	fields := map[string]interface{}{}
+	redirect := ""
+	created := []string{}
+
+	// The stored files are removed if the form is not stored completely
+	var err error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, name := range created {
+			docFilePath := filepath.Join(w.Root, filepath.FromSlash(docDir), name)
+			if info, statErr := os.Stat(docFilePath); statErr == nil {
+				if os.Remove(docFilePath) == nil {
+					w.updateUsage(-info.Size(), -1)
+					w.notify(EventDelete, urlpath.Join(docDir, name))
+				}
+			}
+		}
+		log.Debug().Err(err).Msgf("Post form failed: %s", urlpath.Join(docDir, docName))
+		if errors.Is(err, errFormInvalid) {
+			res.WriteHeader(http.StatusBadRequest)
+			res.Write([]byte(err.Error()))
+			return
+		}
+		if errors.Is(err, errFormDenied) {
+			w.serveError(http.StatusForbidden, res, req)
+			return
+		}
+		w.serveWriteError(err, res, req)
+	}()
+
+	addField := func(name string, value interface{}) {
+		switch existing := fields[name].(type) {
+		case nil:
+			fields[name] = value
+		case []interface{}:
+			fields[name] = append(existing, value)
+		default:
+			fields[name] = []interface{}{existing, value}
+		}
+	}
+
+	if reader, mpErr := req.MultipartReader(); mpErr == nil {
+		fieldsSize := int64(0)
+		for {
+			part, partErr := reader.NextPart()
+			if partErr == io.EOF {
+				break
+			}
+			if partErr != nil {
+				err = partErr
+				return
+			}
+
+			if part.FileName() == "" {
+				value := &bytes.Buffer{}
+				n, copyErr := io.CopyN(value, part, maxFormFieldsSize-fieldsSize+1)
+				if copyErr != nil && copyErr != io.EOF {
+					err = copyErr
+					return
+				}
+				fieldsSize += n
+				if fieldsSize > maxFormFieldsSize {
+					err = fmt.Errorf("%w: fields too large", errFormInvalid)
+					return
+				}
+				if part.FormName() == RedirectField {
+					redirect = value.String()
+					continue
+				}
+				addField(part.FormName(), value.String())
+				continue
+			}
+
+			name := filepath.Base(filepath.FromSlash(part.FileName()))
+			if name == "." || name == ".." || strings.ContainsAny(name[:1], "._!") ||
+				strings.Contains(name, "*") {
+				err = fmt.Errorf("%w: file name: %s", errFormInvalid, part.FileName())
+				return
+			}
+			// The files are authorized as documents posted to the directory
+			principal := auth.Ctx(req.Context())
+			allowed, authErr := w.authorize(principal, "POST", urlpath.Join(docDir, name))
+			if authErr != nil {
+				err = authErr
+				return
+			}
+			if !allowed {
+				log.Debug().Msgf("Access denied: %s POST %s", principal.Name, urlpath.Join(docDir, name))
+				err = fmt.Errorf("%w: %s", errFormDenied, name)
+				return
+			}
+			name, nbytes, createErr := w.createDocFile(docDir, name, part, -1)
+			if createErr != nil {
+				err = createErr
+				return
+			}
+			log.Trace().Msgf("Form file copied to file: %s: %d bytes", name, nbytes)
+			created = append(created, name)
+			addField(part.FormName(), urlpath.Join("/", w.Slug, docDir, name))
+		}
+	} else {
+		if err = req.ParseForm(); err != nil {
+			err = fmt.Errorf("%w: %s", errFormInvalid, err)
+			return
+		}
+		for name, values := range req.PostForm {
+			for _, value := range values {
+				if name == RedirectField {
+					redirect = value
+					continue
+				}
+				addField(name, value)
+			}
+		}
+	}
+
+	if redirect != "" && !localRedirect(redirect) {
+		err = fmt.Errorf("%w: redirect must be an absolute path: %s", errFormInvalid, redirect)
+		return
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	docName, _, err = w.createDocFile(docDir, docName, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return
+	}
+	docPaths := []string{urlpath.Join(docDir, docName)}
+	for _, name := range created {
+		docPaths = append(docPaths, urlpath.Join(docDir, name))
+	}
+	w.record(req.Context(), "POST", docPaths...)
+
+	req.Body.Close()
+
+	if redirect != "" {
+		res.Header().Add("Location", redirect)
+		res.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	location := urlpath.Join("/", w.Slug, docDir, docName)
+	res.Header().Add("Location", location)
+	res.WriteHeader(http.StatusCreated)
+}
+
+// localRedirect returns true if the redirect is an absolute path on this
+// server, the backslashes and control characters are rejected since they
+// are ignored or handled as slashes by the browsers
+func localRedirect(redirect string) bool {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		return false
+	}
+	if strings.IndexFunc(redirect, func(r rune) bool { return r == '\\' || r < ' ' || r == 0x7f }) >= 0 {
+		return false
+	}
+	u, err := url.Parse(redirect)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
@@ -0,0 +1,158 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostForm(t *testing.T) {
+	root := t.TempDir()
+
+	w := New(&testMakeshiftd{}, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+
+	multipartBody := func(files map[string]string, fields ...string) (string, string) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for idx := 0; idx < len(fields); idx += 2 {
+			mw.WriteField(fields[idx], fields[idx+1])
+		}
+		for name, content := range files {
+			fw, err := mw.CreateFormFile("attachment", name)
+			require.Nil(t, err, err)
+			fw.Write([]byte(content))
+		}
+		mw.Close()
+		return mw.FormDataContentType(), body.String()
+	}
+
+	urlencoded := url.Values{"name": {"Alice"}, "tags": {"a", "b"}}.Encode()
+	mpType, mpBody := multipartBody(map[string]string{"photo.txt": "content"}, "name", "Bob", RedirectField, "/test/thanks.html")
+	badType, badBody := multipartBody(map[string]string{"ok.txt": "content", "_bad.txt": "content"}, "name", "Eve")
+	badRedirect := url.Values{"name": {"Mallory"}, RedirectField: {"/\\evil.example.com"}}.Encode()
+
+	table := []struct {
+		Name        string
+		Path        string
+		ContentType string
+		Body        string
+		Code        int
+		Location    string
+		Doc         map[string]interface{}
+		Files       int64
+	}{
+		{
+			Name: "URLEncoded", Path: "/forms/contact.json", ContentType: "application/x-www-form-urlencoded", Body: urlencoded,
+			Code: http.StatusCreated, Location: "/test/forms/contact.json",
+			Doc:   map[string]interface{}{"name": "Alice", "tags": []interface{}{"a", "b"}},
+			Files: 1,
+		},
+		{
+			Name: "Multipart", Path: "/forms/upload.json", ContentType: mpType, Body: mpBody,
+			Code: http.StatusSeeOther, Location: "/test/thanks.html",
+			Doc:   map[string]interface{}{"name": "Bob", "attachment": "/test/forms/photo.txt"},
+			Files: 3,
+		},
+		{
+			Name: "InvalidFileName", Path: "/forms/bad.json", ContentType: badType, Body: badBody,
+			Code: http.StatusBadRequest, Files: 3,
+		},
+		{
+			Name: "InvalidRedirect", Path: "/forms/redirect.json", ContentType: "application/x-www-form-urlencoded", Body: badRedirect,
+			Code: http.StatusBadRequest, Files: 3,
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", row.Path, strings.NewReader(row.Body))
+			req.Header.Set("Content-Type", row.ContentType)
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code)
+			require.Equal(t, row.Location, res.Header().Get("Location"))
+			require.Equal(t, row.Files, w.Usage().Files)
+
+			data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(row.Path)))
+			if row.Doc == nil {
+				require.True(t, os.IsNotExist(err))
+				return
+			}
+			require.Nil(t, err, err)
+			doc := map[string]interface{}{}
+			err = json.Unmarshal(data, &doc)
+			require.Nil(t, err, err)
+			require.Equal(t, row.Doc, doc)
+		})
+	}
+
+	_, err := os.Stat(filepath.Join(root, "forms", "ok.txt"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestLocalRedirect(t *testing.T) {
+	table := []struct {
+		Redirect string
+		Local    bool
+	}{
+		{Redirect: "/test/thanks.html", Local: true},
+		{Redirect: "/test/thanks.html?id=1#top", Local: true},
+		{Redirect: "thanks.html", Local: false},
+		{Redirect: "//evil.example.com", Local: false},
+		{Redirect: "/\\evil.example.com", Local: false},
+		{Redirect: "/\t/evil.example.com", Local: false},
+		{Redirect: "https://evil.example.com", Local: false},
+	}
+
+	for _, row := range table {
+		t.Run(row.Redirect, func(t *testing.T) {
+			require.Equal(t, row.Local, localRedirect(row.Redirect))
+		})
+	}
+}
+
+func TestPostFormAccess(t *testing.T) {
+	root := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(root, "forms"), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.WriteFile(filepath.Join(root, "forms", AccessFileName), []byte(`{"rules": [{"paths": ["*.html"], "methods": ["POST"], "principals": ["*"], "effect": "deny"}]}`), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+	usage := w.Usage()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("name", "Mallory")
+	for _, name := range []string{"ok.txt", "page.html"} {
+		fw, err := mw.CreateFormFile("attachment", name)
+		require.Nil(t, err, err)
+		fw.Write([]byte("content"))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/forms/entry.json", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res := httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.Equal(t, http.StatusForbidden, res.Code)
+
+	// The files stored before the denied file are removed
+	for _, name := range []string{"entry.json", "ok.txt", "page.html"} {
+		_, err := os.Stat(filepath.Join(root, "forms", name))
+		require.True(t, os.IsNotExist(err), name)
+	}
+	require.Equal(t, usage, w.Usage())
+}
@@ -0,0 +1,567 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/context"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// HistoryAPI is the segment of the workspace API serving the revisions of the documents
+const HistoryAPI = "_history"
+
+// historyDir is the directory of the repository of the history in the state directory
+const historyDir = "history"
+
+// RevisionQuery is the query of the revision of a document in the history
+const RevisionQuery = "rev"
+
+// historyAuthor is the author of the commit importing the existing documents
+const historyAuthor = "makeshiftd"
+
+// Limits of the number of revisions of a document served
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// Revision is a commit of the workspace history changing a document
+type Revision struct {
+	Revision string    `json:"revision"`
+	Author   string    `json:"author"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+}
+
+// history is the Git repository in the state directory of the workspace,
+// with the root of the workspace as the worktree, in which each change of
+// the documents by the server is committed. A repository of the user in
+// the root of the workspace is not used so that it is never modified.
+type history struct {
+	mtx  sync.Mutex
+	repo *git.Repository
+}
+
+// openHistory opens the repository of the history of the workspace, a new
+// repository is created with a commit of the existing documents, the size
+// of the repository is added to the usage of the workspace as it changes
+func (w *Workspace) openHistory() (*history, error) {
+	dir := w.statePath(historyDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	storage := filesystem.NewStorage(&historyFS{Filesystem: osfs.New(dir), usage: w.updateUsage}, cache.NewObjectLRUDefault())
+	worktree := osfs.New(w.Root)
+
+	repo, err := git.Open(storage, worktree)
+	if err == nil {
+		return &history{repo: repo}, nil
+	}
+	if !errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, err
+	}
+	// The repository is created without a worktree so that no '.git' file
+	// linking to the repository is written to the root of the workspace
+	if _, err = git.Init(storage, nil); err != nil {
+		return nil, err
+	}
+	repo, err = git.Open(storage, worktree)
+	if err != nil {
+		return nil, err
+	}
+	h := &history{repo: repo}
+	return h, h.commit(w.Root, auth.Principal{Name: historyAuthor}, "Import documents", "/")
+}
+
+// historyFS is the file system of the history repository, the change in
+// the size of the files written or removed is added to the usage of the
+// workspace so that the history counts against the quota
+type historyFS struct {
+	billy.Filesystem
+	usage func(bytes, files int64)
+}
+
+// size returns the size of the file or -1 if it does not exist
+func (fs *historyFS) size(name string) int64 {
+	info, err := fs.Filesystem.Stat(name)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+func (fs *historyFS) Create(name string) (billy.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *historyFS) OpenFile(name string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return fs.Filesystem.OpenFile(name, flag, perm)
+	}
+	size := fs.size(name)
+	f, err := fs.Filesystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &historyFile{File: f, fs: fs, size: size}, nil
+}
+
+func (fs *historyFS) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &historyFile{File: f, fs: fs, size: -1}, nil
+}
+
+func (fs *historyFS) Rename(from, to string) error {
+	size := fs.size(to)
+	err := fs.Filesystem.Rename(from, to)
+	if err == nil && size >= 0 {
+		fs.usage(-size, 0)
+	}
+	return err
+}
+
+func (fs *historyFS) Remove(name string) error {
+	size := fs.size(name)
+	err := fs.Filesystem.Remove(name)
+	if err == nil && size >= 0 {
+		fs.usage(-size, 0)
+	}
+	return err
+}
+
+// historyFile is a file of the history repository opened for writing,
+// the size of the file before it was opened is -1 if it did not exist
+type historyFile struct {
+	billy.File
+	fs   *historyFS
+	size int64
+}
+
+func (f *historyFile) Close() error {
+	err := f.File.Close()
+	if size := f.fs.size(f.Name()); size >= 0 {
+		if f.size >= 0 {
+			size -= f.size
+		}
+		f.fs.usage(size, 0)
+	}
+	return err
+}
+
+// record commits the changes of the documents to the history of the workspace,
+// if enabled, the message is the action followed by the paths of the documents
+// and the principal of the context is the author of the commit
+func (w *Workspace) record(ctx context.C, action string, docPaths ...string) {
+	if w.history == nil {
+		return
+	}
+	message := action
+	for _, docPath := range docPaths {
+		message += " " + urlpath.Join("/", docPath)
+	}
+	err := w.history.commit(w.Root, auth.Ctx(ctx), message, docPaths...)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msgf("History commit failed: %s", message)
+	}
+}
+
+// commit stages the documents, or the documents of the directories, at the
+// paths and commits the changes, nothing is committed if nothing changed
+func (h *history) commit(root string, principal auth.Principal, message string, docPaths ...string) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	idx, err := h.repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, docPath := range docPaths {
+		name := strings.Trim(docPath, "/")
+		replaced := map[string]plumbing.Hash{}
+		entries := []*index.Entry{}
+		for _, entry := range idx.Entries {
+			if name == "" || entry.Name == name || strings.HasPrefix(entry.Name, name+"/") {
+				replaced[entry.Name] = entry.Hash
+				continue
+			}
+			entries = append(entries, entry)
+		}
+
+		added, err := h.stage(root, name)
+		if err != nil {
+			return err
+		}
+		for _, entry := range added {
+			if hash, ok := replaced[entry.Name]; !ok || hash != entry.Hash {
+				changed = true
+			}
+			delete(replaced, entry.Name)
+		}
+		if len(replaced) > 0 {
+			changed = true
+		}
+		idx.Entries = append(entries, added...)
+	}
+	if !changed {
+		return nil
+	}
+
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		return idx.Entries[i].Name < idx.Entries[j].Name
+	})
+	if err := h.repo.Storer.SetIndex(idx); err != nil {
+		return err
+	}
+
+	worktree, err := h.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	author := principal.Name
+	if principal.IsAnonymous() {
+		author = auth.Anonymous
+	}
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{Name: author, When: time.Now()},
+	})
+	return err
+}
+
+// stage writes the blobs of the files at the path, or of the files of the
+// directory, and returns their index entries, hidden files are not staged
+func (h *history) stage(root, name string) ([]*index.Entry, error) {
+	entries := []*index.Entry{}
+	err := filepath.WalkDir(filepath.Join(root, filepath.FromSlash(name)), func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		mode, err := filemode.NewFromOSFileMode(info.Mode())
+		if err != nil {
+			return err
+		}
+
+		hash, err := h.writeBlob(path, info.Size())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &index.Entry{
+			Name:       filepath.ToSlash(rel),
+			Hash:       hash,
+			Mode:       mode,
+			Size:       uint32(info.Size()),
+			ModifiedAt: info.ModTime(),
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// writeBlob writes the content of the file to the repository
+func (h *history) writeBlob(path string, size int64) (plumbing.Hash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer f.Close()
+
+	obj := h.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(size)
+	writer, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	_, err = io.Copy(writer, f)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return h.repo.Storer.SetEncodedObject(obj)
+}
+
+// revisions returns the commits changing the document, or the documents of the directory, newest first
+func (h *history) revisions(docPath string, limit int) ([]Revision, error) {
+	name := strings.Trim(docPath, "/")
+	revisions := []Revision{}
+	commits, err := h.repo.Log(&git.LogOptions{
+		Order: git.LogOrderCommitterTime,
+		PathFilter: func(path string) bool {
+			return path == name || strings.HasPrefix(path, name+"/")
+		},
+	})
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return revisions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	err = commits.ForEach(func(c *object.Commit) error {
+		if len(revisions) >= limit {
+			return storer.ErrStop
+		}
+		revisions = append(revisions, Revision{
+			Revision: c.Hash.String(),
+			Author:   c.Author.Name,
+			Time:     c.Author.When.UTC(),
+			Message:  c.Message,
+		})
+		return nil
+	})
+	return revisions, err
+}
+
+// file returns the content of the document at the revision, or nil if
+// the document does not exist at the revision
+func (h *history) file(docPath, rev string) ([]byte, plumbing.Hash, error) {
+	if len(rev) != 40 {
+		return nil, plumbing.ZeroHash, nil
+	}
+	if _, err := hex.DecodeString(rev); err != nil {
+		return nil, plumbing.ZeroHash, nil
+	}
+	commit, err := h.repo.CommitObject(plumbing.NewHash(rev))
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	file, err := commit.File(strings.Trim(docPath, "/"))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	return data, file.Hash, err
+}
+
+// serveHistory serves the revisions of the document of the 'path' query, or
+// the document at the revision of the 'rev' query, the document is reverted
+// to the revision by a POST
+func (w *Workspace) serveHistory(res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	if w.history == nil {
+		w.serveError(http.StatusNotFound, res, req)
+		return
+	}
+
+	switch req.Method {
+	case "GET", "HEAD", "POST":
+	case "OPTIONS":
+		res.Header().Set("Allow", "GET, HEAD, POST, OPTIONS")
+		res.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		res.Header().Set("Allow", "GET, HEAD, POST, OPTIONS")
+		w.serveError(http.StatusMethodNotAllowed, res, req)
+		return
+	}
+
+	query := req.URL.Query()
+	segments, exec, ok := docSegments(query.Get("path"))
+	if !ok || exec || len(segments) == 0 {
+		w.serveError(http.StatusBadRequest, res, req)
+		return
+	}
+	docPath := urlpath.Join(append([]string{"/"}, segments...)...)
+	rev := query.Get(RevisionQuery)
+	if req.Method == "POST" && rev == "" {
+		w.serveError(http.StatusBadRequest, res, req)
+		return
+	}
+
+	method := "GET"
+	if req.Method == "POST" {
+		method = "PUT"
+	}
+	principal := auth.Ctx(req.Context())
+	allowed, err := w.authorize(principal, method, docPath)
+	if err != nil {
+		log.Warn().Err(err).Msg("Access rules evaluation failed")
+		w.serveError(err, res, req)
+		return
+	}
+	if !allowed {
+		log.Debug().Msgf("Access denied: %s %s %s", principal.Name, method, docPath)
+		w.serveError(http.StatusForbidden, res, req)
+		return
+	}
+
+	if rev == "" {
+		limit := defaultHistoryLimit
+		if value := query.Get(LimitQuery); value != "" {
+			if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+				w.serveError(http.StatusBadRequest, res, req)
+				return
+			}
+			if limit > maxHistoryLimit {
+				limit = maxHistoryLimit
+			}
+		}
+		revisions, err := w.history.revisions(docPath, limit)
+		if err != nil {
+			w.serveError(err, res, req)
+			return
+		}
+		data, err := json.Marshal(revisions)
+		if err != nil {
+			w.serveError(err, res, req)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "no-cache")
+		res.WriteHeader(http.StatusOK)
+		if req.Method != "HEAD" {
+			res.Write(data)
+		}
+		return
+	}
+
+	data, hash, err := w.history.file(docPath, rev)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	if data == nil {
+		w.serveError(http.StatusNotFound, res, req)
+		return
+	}
+
+	if req.Method == "POST" {
+		w.serveRevert(docPath, rev, data, res, req)
+		return
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(docPath)); contentType != "" {
+		res.Header().Set("Content-Type", contentType)
+	}
+	res.Header().Set("ETag", fmt.Sprintf(`"%s"`, hash))
+	http.ServeContent(res, req, docPath, time.Time{}, bytes.NewReader(data))
+}
+
+// serveRevert replaces the document with its content at the revision
+func (w *Workspace) serveRevert(docPath, rev string, data []byte, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	docFilePath := filepath.Join(w.Root, filepath.FromSlash(docPath))
+	docFileInfo, err := os.Stat(docFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		w.serveError(err, res, req)
+		return
+	}
+	if err == nil && docFileInfo.IsDir() {
+		w.serveError(http.StatusConflict, res, req)
+		return
+	}
+	if err != nil {
+		docFileInfo = nil
+	}
+
+	var replacedBytes int64
+	if docFileInfo != nil {
+		replacedBytes = docFileInfo.Size()
+	}
+	err = w.validateDoc(docPath, data)
+	if err != nil {
+		log.Debug().Err(err).Msgf("Revert failed: %s", docPath)
+		w.serveWriteError(err, res, req)
+		return
+	}
+	reservation, err := w.reserveUsage(replacedBytes, int64(len(data)), docFileInfo == nil)
+	if err != nil {
+		log.Debug().Err(err).Msgf("Revert failed: %s", docPath)
+		w.serveWriteError(err, res, req)
+		return
+	}
+	var usedBytes, usedFiles int64
+	defer func() { reservation.release(usedBytes, usedFiles) }()
+
+	if docFileInfo == nil {
+		err = os.MkdirAll(filepath.Dir(docFilePath), os.ModePerm)
+	}
+	if err == nil {
+		_, err = writeDocFile(docFilePath, docFileInfo, bytes.NewReader(data))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("Revert failed: %s", docPath)
+		w.serveWriteError(err, res, req)
+		return
+	}
+
+	usedBytes = int64(len(data)) - replacedBytes
+	if docFileInfo == nil {
+		usedFiles = 1
+	}
+	w.record(req.Context(), "REVERT "+rev[:7], docPath)
+	if docFileInfo != nil {
+		w.notify(EventModify, docPath)
+		res.WriteHeader(http.StatusNoContent)
+	} else {
+		w.notify(EventCreate, docPath)
+		res.WriteHeader(http.StatusCreated)
+	}
+}
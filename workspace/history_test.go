@@ -0,0 +1,154 @@
+package workspace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+)
+
+func TestHistory(t *testing.T) {
+	root := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(root, "docs"), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("v1"), os.ModePerm)
+	require.Nil(t, err, err)
+
+	config := viper.New()
+	config.Set("history.enabled", true)
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	serve := func(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Name: "ann"}))
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		return res
+	}
+	revisions := func(t *testing.T, docPath string) []Revision {
+		res := serve(t, "GET", "/"+HistoryAPI+"?path="+docPath, "")
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		require.Equal(t, "application/json", res.Header().Get("Content-Type"))
+		revisions := []Revision{}
+		err := json.Unmarshal(res.Body.Bytes(), &revisions)
+		require.Nil(t, err, err)
+		return revisions
+	}
+
+	require.Equal(t, http.StatusOK, serve(t, "PUT", "/docs/a.txt", "v22").Code)
+	// The unchanged document is not committed again
+	require.Equal(t, http.StatusOK, serve(t, "PUT", "/docs/a.txt", "v22").Code)
+	require.Equal(t, http.StatusCreated, serve(t, "PUT", "/docs/b.txt", "b").Code)
+	req := httptest.NewRequest("MOVE", "/docs/b.txt", nil)
+	req.Header.Set("Destination", "/test/docs/c.txt")
+	res := httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+
+	history := revisions(t, "/docs/a.txt")
+	require.Len(t, history, 2)
+	require.Equal(t, "ann", history[0].Author)
+	require.Equal(t, "PUT /docs/a.txt", history[0].Message)
+	require.Equal(t, historyAuthor, history[1].Author)
+	require.Len(t, revisions(t, "/docs/c.txt"), 1)
+	require.Len(t, revisions(t, "/docs"), 4)
+	require.Len(t, revisions(t, "/missing.txt"), 0)
+
+	table := []struct {
+		Method string
+		Path   string
+		Code   int
+		Body   string
+	}{
+		{Method: "GET", Path: "/docs/a.txt&rev=" + history[1].Revision, Code: http.StatusOK, Body: "v1"},
+		{Method: "GET", Path: "/docs/a.txt&rev=" + history[0].Revision, Code: http.StatusOK, Body: "v22"},
+		{Method: "GET", Path: "/docs/c.txt&rev=" + history[1].Revision, Code: http.StatusNotFound},
+		{Method: "GET", Path: "/docs/a.txt&rev=abc", Code: http.StatusNotFound},
+		{Method: "GET", Path: "/_hidden&rev=" + history[0].Revision, Code: http.StatusBadRequest},
+		{Method: "POST", Path: "/docs/a.txt", Code: http.StatusBadRequest},
+		{Method: "PUT", Path: "/docs/a.txt", Code: http.StatusMethodNotAllowed},
+	}
+
+	for _, row := range table {
+		t.Run(row.Method+":"+row.Path, func(t *testing.T) {
+			res := serve(t, row.Method, "/"+HistoryAPI+"?path="+row.Path, "")
+			require.Equal(t, row.Code, res.Code)
+			if row.Code == http.StatusOK {
+				require.Equal(t, row.Body, res.Body.String())
+				require.NotEmpty(t, res.Header().Get("ETag"))
+			}
+		})
+	}
+
+	t.Run("Revert", func(t *testing.T) {
+		res := serve(t, "POST", "/"+HistoryAPI+"?path=/docs/a.txt&rev="+history[1].Revision, "")
+		require.Equal(t, http.StatusNoContent, res.Code)
+		data, err := os.ReadFile(filepath.Join(root, "docs", "a.txt"))
+		require.Nil(t, err, err)
+		require.Equal(t, "v1", string(data))
+
+		reverted := revisions(t, "/docs/a.txt")
+		require.Len(t, reverted, 3)
+		require.Equal(t, "REVERT "+history[1].Revision[:7]+" /docs/a.txt", reverted[0].Message)
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		// The history counts against the bytes of the quota but not the files
+		usage := w.Usage()
+		require.Equal(t, int64(2), usage.Files)
+		require.Greater(t, usage.Bytes, int64(3))
+		err := w.reconcileUsage()
+		require.Nil(t, err, err)
+		require.Equal(t, usage, w.Usage())
+	})
+
+	t.Run("Repository", func(t *testing.T) {
+		// A repository of the user in the root is not used
+		root := t.TempDir()
+		repo, err := git.PlainInit(root, false)
+		require.Nil(t, err, err)
+		err = os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), os.ModePerm)
+		require.Nil(t, err, err)
+		worktree, err := repo.Worktree()
+		require.Nil(t, err, err)
+		_, err = worktree.Add("a.txt")
+		require.Nil(t, err, err)
+
+		w := New(&testMakeshiftd{}, "repo", root, config)
+		require.Nil(t, w.err, w.err)
+		req := httptest.NewRequest("PUT", "/b.txt", strings.NewReader("b"))
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		require.Equal(t, http.StatusCreated, res.Code)
+
+		_, err = repo.Head()
+		require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+		status, err := worktree.Status()
+		require.Nil(t, err, err)
+		require.Equal(t, git.Added, status.File("a.txt").Staging)
+		require.Equal(t, git.Untracked, status.File("b.txt").Staging)
+		_, err = os.Stat(filepath.Join(root, StateDir, historyDir))
+		require.Nil(t, err, err)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		w := New(&testMakeshiftd{}, "other", root, nil)
+		require.Nil(t, w.err, w.err)
+		req := httptest.NewRequest("GET", "/"+HistoryAPI+"?path=/docs/a.txt", nil)
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		require.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
@@ -0,0 +1,230 @@
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/makeshiftd/makeshiftd/metrics"
+)
+
+// PointerQuery is the query parameter with the JSON Pointer of the
+// fragment of a JSON document to get, replace, patch or remove
+const PointerQuery = "ptr"
+
+// JSONPathQuery is the query parameter with the JSONPath expression
+// selecting the fragments of a JSON document to get
+const JSONPathQuery = "jsonpath"
+
+// jsonQuery returns true if the request is for fragments of a JSON document
+func jsonQuery(req *http.Request) bool {
+	query := req.URL.Query()
+	_, ptr := query[PointerQuery]
+	_, path := query[JSONPathQuery]
+	return ptr || path
+}
+
+// decodeJSON decodes the JSON value, the numbers are kept as is
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("JSON value followed by data")
+	}
+	return value, nil
+}
+
+// encodeJSON encodes the JSON value with the indent of the original document
+func encodeJSON(value interface{}, original []byte) ([]byte, error) {
+	out := &bytes.Buffer{}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	if lines := bytes.SplitN(original, []byte("\n"), 3); len(lines) > 1 {
+		line := lines[1]
+		indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+		if len(indent) > 0 {
+			encoder.SetIndent("", string(indent))
+		}
+	}
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// serveDocJSON serves the fragments of the JSON document selected by the JSON
+// Pointer or the JSONPath expression, or modifies the fragment of the pointer
+func (w *Workspace) serveDocJSON(docPath string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	query := req.URL.Query()
+	_, selectPath := query[JSONPathQuery]
+	if selectPath && req.Method != "GET" && req.Method != "HEAD" {
+		res.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.serveError(http.StatusMethodNotAllowed, res, req)
+		return
+	}
+
+	docFilePath := filepath.Join(w.Root, filepath.FromSlash(docPath))
+	log.Debug().Msgf("JSON file path: %s", docFilePath)
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		unlock := lockFile(docFilePath)
+		defer unlock()
+	}
+
+	docFileInfo, err := os.Stat(docFilePath)
+	if (err == nil && docFileInfo.IsDir()) || os.IsNotExist(err) {
+		w.serveError(http.StatusNotFound, res, req)
+		return
+	}
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	original, err := os.ReadFile(docFilePath)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	doc, err := decodeJSON(original)
+	if err != nil {
+		log.Debug().Err(err).Msgf("JSON document invalid: %s", docFilePath)
+		res.WriteHeader(http.StatusConflict)
+		res.Write([]byte("Document is not valid JSON"))
+		return
+	}
+
+	if selectPath {
+		steps, err := parseJSONPath(query.Get(JSONPathQuery))
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			res.Write([]byte(err.Error()))
+			return
+		}
+		w.serveJSON(evalJSONPath(doc, steps), res, req)
+		return
+	}
+
+	tokens, err := parsePointer(query.Get(PointerQuery))
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		res.Write([]byte(err.Error()))
+		return
+	}
+
+	var value interface{}
+	if req.Method == "PUT" || req.Method == "PATCH" {
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if mediaType != "" && mediaType != "application/json" && mediaType != "application/merge-patch+json" {
+			w.serveError(http.StatusUnsupportedMediaType, res, req)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.serveWriteError(err, res, req)
+			return
+		}
+		value, err = decodeJSON(body)
+		if err != nil {
+			res.WriteHeader(http.StatusUnprocessableEntity)
+			res.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	created := false
+	switch req.Method {
+	case "GET", "HEAD":
+		value, err = getPointer(doc, tokens)
+		if err == nil {
+			w.serveJSON(value, res, req)
+			return
+		}
+	case "PUT":
+		doc, created, err = setPointer(doc, tokens, value)
+	case "PATCH":
+		var target interface{}
+		target, err = getPointer(doc, tokens)
+		if err == nil {
+			doc, _, err = setPointer(doc, tokens, mergePatch(target, value))
+		}
+	case "DELETE":
+		if len(tokens) == 0 {
+			res.WriteHeader(http.StatusBadRequest)
+			res.Write([]byte("JSON Pointer of the document cannot be removed"))
+			return
+		}
+		doc, err = removePointer(doc, tokens)
+	default:
+		res.Header().Set("Allow", "GET, HEAD, PUT, PATCH, DELETE, OPTIONS")
+		w.serveError(http.StatusMethodNotAllowed, res, req)
+		return
+	}
+	if errors.Is(err, errPointerNotFound) {
+		w.serveError(http.StatusNotFound, res, req)
+		return
+	}
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+
+	data, err := encodeJSON(doc, original)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	err = w.validateDoc(docPath, data)
+	if err != nil {
+		w.serveWriteError(err, res, req)
+		return
+	}
+	reservation, err := w.reserveUsage(docFileInfo.Size(), int64(len(data)), false)
+	if err == nil {
+		_, err = writeDocFile(docFilePath, docFileInfo, bytes.NewReader(data))
+		if err != nil {
+			reservation.release(0, 0)
+		}
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("JSON document write failed: %s", docFilePath)
+		w.serveWriteError(err, res, req)
+		return
+	}
+	metrics.WrittenBytes.WithLabelValues(w.Slug).Add(float64(len(data)))
+	reservation.release(int64(len(data))-docFileInfo.Size(), 0)
+	w.record(req.Context(), req.Method, docPath)
+	w.notify(EventModify, docPath)
+
+	if created {
+		res.WriteHeader(http.StatusCreated)
+	} else {
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serveJSON serves the JSON value, the ETag is the digest of the value
+func (w *Workspace) serveJSON(value interface{}, res http.ResponseWriter, req *http.Request) {
+	data, err := encodeJSON(value, nil)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(data)))
+	res.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(res, req, "", time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,73 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocJSON(t *testing.T) {
+	root := t.TempDir()
+
+	doc := "{\n    \"items\": [\n        {\n            \"name\": \"x\"\n        }\n    ],\n    \"n\": 12345678901234567890\n}\n"
+	err := os.WriteFile(filepath.Join(root, "data.json"), []byte(doc), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.WriteFile(filepath.Join(root, "text.json"), []byte("not json"), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.WriteFile(filepath.Join(root, "page.txt"), []byte("text"), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+
+	table := []struct {
+		Method string
+		Path   string
+		Body   string
+		Code   int
+		Result string
+		Doc    string
+	}{
+		{Method: "GET", Path: "/data.json?ptr=/items/0/name", Code: http.StatusOK, Result: `"x"`},
+		{Method: "GET", Path: "/data.json?ptr=/n", Code: http.StatusOK, Result: `12345678901234567890`},
+		{Method: "GET", Path: "/data.json?ptr=/items/1", Code: http.StatusNotFound},
+		{Method: "GET", Path: "/data.json?ptr=items", Code: http.StatusBadRequest},
+		{Method: "GET", Path: "/data.json?jsonpath=$.items[*].name", Code: http.StatusOK, Result: `["x"]`},
+		{Method: "GET", Path: "/missing.json?ptr=/a", Code: http.StatusNotFound},
+		{Method: "GET", Path: "/text.json?ptr=/a", Code: http.StatusConflict},
+		{Method: "PUT", Path: "/data.json?jsonpath=$.items", Body: `1`, Code: http.StatusMethodNotAllowed},
+		{Method: "PUT", Path: "/data.json?ptr=/items/-", Body: `{"name":"<y>"}`, Code: http.StatusCreated},
+		{Method: "PUT", Path: "/data.json?ptr=/items/0/name", Body: `"w"`, Code: http.StatusNoContent},
+		{Method: "PUT", Path: "/data.json?ptr=/items/0", Body: `{`, Code: http.StatusUnprocessableEntity},
+		{Method: "PATCH", Path: "/data.json?ptr=/items/1", Body: `{"id":2}`, Code: http.StatusNoContent},
+		{Method: "DELETE", Path: "/data.json?ptr=/items/0", Code: http.StatusNoContent},
+		{Method: "DELETE", Path: "/data.json?ptr=/items/3", Code: http.StatusNotFound},
+		{Method: "DELETE", Path: "/data.json?ptr=", Code: http.StatusBadRequest},
+		{Method: "PATCH", Path: "/page.txt", Body: `{"n":1}`, Code: http.StatusMethodNotAllowed},
+		{Method: "PATCH", Path: "/data.json", Body: `{"n":null}`, Code: http.StatusNoContent,
+			Doc: "{\n    \"items\": [\n        {\n            \"id\": 2,\n            \"name\": \"<y>\"\n        }\n    ]\n}\n"},
+	}
+
+	for _, row := range table {
+		t.Run(row.Method+":"+row.Path, func(t *testing.T) {
+			req := httptest.NewRequest(row.Method, row.Path, strings.NewReader(row.Body))
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code, res.Body.String())
+			if row.Result != "" {
+				require.Equal(t, "application/json", res.Header().Get("Content-Type"))
+				require.JSONEq(t, row.Result, res.Body.String())
+			}
+			if row.Doc != "" {
+				data, err := os.ReadFile(filepath.Join(root, "data.json"))
+				require.Nil(t, err, err)
+				require.Equal(t, row.Doc, string(data))
+			}
+		})
+	}
+}
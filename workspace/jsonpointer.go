@@ -0,0 +1,296 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errPointerNotFound is the error if the JSON Pointer does not refer to a value
+var errPointerNotFound = errors.New("JSON Pointer not found")
+
+// parsePointer parses the JSON Pointer (RFC 6901) into the unescaped reference tokens
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("JSON Pointer invalid: %s", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for idx, token := range tokens {
+		token = strings.ReplaceAll(token, "~1", "/")
+		tokens[idx] = strings.ReplaceAll(token, "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex returns the index of the token in an array of the length,
+// the index may be equal to the length if the token is '-'
+func arrayIndex(token string, length int) (int, bool) {
+	if token == "-" {
+		return length, true
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > length {
+		return 0, false
+	}
+	return idx, true
+}
+
+// getPointer returns the value referred to by the tokens
+func getPointer(doc interface{}, tokens []string) (interface{}, error) {
+	value := doc
+	for _, token := range tokens {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			child, ok := v[token]
+			if !ok {
+				return nil, errPointerNotFound
+			}
+			value = child
+		case []interface{}:
+			idx, ok := arrayIndex(token, len(v))
+			if !ok || idx == len(v) {
+				return nil, errPointerNotFound
+			}
+			value = v[idx]
+		default:
+			return nil, errPointerNotFound
+		}
+	}
+	return value, nil
+}
+
+// setPointer sets the value referred to by the tokens, the parent of the value
+// must exist, an array element is appended if the index is the length of the
+// array, returns the document and true if the value is created
+func setPointer(doc interface{}, tokens []string, value interface{}) (interface{}, bool, error) {
+	if len(tokens) == 0 {
+		return value, false, nil
+	}
+	parent, err := getPointer(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, false, err
+	}
+	token := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		_, exists := p[token]
+		p[token] = value
+		return doc, !exists, nil
+	case []interface{}:
+		idx, ok := arrayIndex(token, len(p))
+		if !ok {
+			return nil, false, errPointerNotFound
+		}
+		if idx < len(p) {
+			p[idx] = value
+			return doc, false, nil
+		}
+		// The array is reallocated so the reference in its parent is replaced
+		return setPointerParent(doc, tokens[:len(tokens)-1], append(p, value)), true, nil
+	}
+	return nil, false, errPointerNotFound
+}
+
+// removePointer removes the value referred to by the tokens, the document
+// itself cannot be removed
+func removePointer(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errPointerNotFound
+	}
+	parent, err := getPointer(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := p[token]; !ok {
+			return nil, errPointerNotFound
+		}
+		delete(p, token)
+		return doc, nil
+	case []interface{}:
+		idx, ok := arrayIndex(token, len(p))
+		if !ok || idx == len(p) {
+			return nil, errPointerNotFound
+		}
+		removed := append(p[:idx:idx], p[idx+1:]...)
+		return setPointerParent(doc, tokens[:len(tokens)-1], removed), nil
+	}
+	return nil, errPointerNotFound
+}
+
+// setPointerParent replaces the existing value referred to by the tokens
+func setPointerParent(doc interface{}, tokens []string, value interface{}) interface{} {
+	doc, _, _ = setPointer(doc, tokens, value)
+	return doc
+}
+
+// mergePatch applies the JSON Merge Patch (RFC 7396) to the target
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], value)
+		}
+	}
+	return t
+}
+
+// jsonPathStep is a step of a JSONPath expression, the name is '*'
+// for any child, the index is used if the name is empty
+type jsonPathStep struct {
+	name      string
+	index     int
+	recursive bool
+}
+
+// parseJSONPath parses the subset of JSONPath with the child ('.name', "['name']"),
+// index ('[0]', '[-1]'), wildcard ('.*', '[*]') and descendant ('..name') steps
+func parseJSONPath(expr string) ([]jsonPathStep, error) {
+	invalid := fmt.Errorf("JSONPath invalid: %s", expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, invalid
+	}
+	rest := expr[1:]
+	steps := []jsonPathStep{}
+	for rest != "" {
+		step := jsonPathStep{}
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(rest, "."):
+			rest = strings.TrimPrefix(rest, ".")
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			step.name, rest = rest[:end], rest[end:]
+			if step.name == "" {
+				return nil, invalid
+			}
+			steps = append(steps, step)
+			continue
+		case !strings.HasPrefix(rest, "["):
+			return nil, invalid
+		}
+
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return nil, invalid
+		}
+		selector := rest[1:end]
+		rest = rest[end+1:]
+		switch {
+		case selector == "*":
+			step.name = "*"
+		case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+			step.name = selector[1 : len(selector)-1]
+			if step.name == "*" || step.name == "" {
+				return nil, invalid
+			}
+		default:
+			idx, err := strconv.Atoi(selector)
+			if err != nil {
+				return nil, invalid
+			}
+			step.index = idx
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// evalJSONPath returns the values of the document matching the steps
+func evalJSONPath(doc interface{}, steps []jsonPathStep) []interface{} {
+	nodes := []interface{}{doc}
+	for _, step := range steps {
+		if step.recursive {
+			descendants := []interface{}{}
+			for _, node := range nodes {
+				descendants = appendDescendants(descendants, node)
+			}
+			nodes = descendants
+		}
+		matches := []interface{}{}
+		for _, node := range nodes {
+			matches = appendChildren(matches, node, step)
+		}
+		nodes = matches
+	}
+	return nodes
+}
+
+// appendDescendants appends the node and its descendants
+func appendDescendants(nodes []interface{}, node interface{}) []interface{} {
+	nodes = append(nodes, node)
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(n) {
+			nodes = appendDescendants(nodes, n[key])
+		}
+	case []interface{}:
+		for _, child := range n {
+			nodes = appendDescendants(nodes, child)
+		}
+	}
+	return nodes
+}
+
+// appendChildren appends the children of the node matching the step
+func appendChildren(nodes []interface{}, node interface{}, step jsonPathStep) []interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if step.name == "*" {
+			for _, key := range sortedKeys(n) {
+				nodes = append(nodes, n[key])
+			}
+		} else if child, ok := n[step.name]; ok && step.name != "" {
+			nodes = append(nodes, child)
+		}
+	case []interface{}:
+		if step.name == "*" {
+			nodes = append(nodes, n...)
+		} else if step.name == "" {
+			idx := step.index
+			if idx < 0 {
+				idx += len(n)
+			}
+			if idx >= 0 && idx < len(n) {
+				nodes = append(nodes, n[idx])
+			}
+		}
+	}
+	return nodes
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
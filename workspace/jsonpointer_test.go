@@ -0,0 +1,137 @@
+package workspace
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testJSONDoc = `{"a/b":1,"m~n":2,"items":[{"name":"x","tags":["t1"]},{"name":"y"}],"nested":{"name":"z"}}`
+
+func TestPointer(t *testing.T) {
+	table := []struct {
+		Pointer string
+		Value   string
+		Err     error
+	}{
+		{Pointer: "", Value: testJSONDoc},
+		{Pointer: "/a~1b", Value: `1`},
+		{Pointer: "/m~0n", Value: `2`},
+		{Pointer: "/items/1/name", Value: `"y"`},
+		{Pointer: "/items/2", Err: errPointerNotFound},
+		{Pointer: "/items/-", Err: errPointerNotFound},
+		{Pointer: "/items/01", Err: errPointerNotFound},
+		{Pointer: "/missing", Err: errPointerNotFound},
+		{Pointer: "/a~1b/c", Err: errPointerNotFound},
+	}
+
+	for _, row := range table {
+		t.Run(row.Pointer, func(t *testing.T) {
+			var doc interface{}
+			require.Nil(t, json.Unmarshal([]byte(testJSONDoc), &doc))
+			tokens, err := parsePointer(row.Pointer)
+			require.Nil(t, err, err)
+			value, err := getPointer(doc, tokens)
+			require.Equal(t, row.Err, err)
+			if row.Err == nil {
+				data, err := json.Marshal(value)
+				require.Nil(t, err, err)
+				require.JSONEq(t, row.Value, string(data))
+			}
+		})
+	}
+
+	_, err := parsePointer("items")
+	require.NotNil(t, err)
+}
+
+func TestPointerWrite(t *testing.T) {
+	table := []struct {
+		Name    string
+		Op      string
+		Pointer string
+		Value   string
+		Doc     string
+		Created bool
+		Err     error
+	}{
+		{Name: "Replace", Op: "set", Pointer: "/items/0/name", Value: `"w"`, Doc: `{"items":[{"name":"w"},{"name":"y"}]}`},
+		{Name: "Add", Op: "set", Pointer: "/items/1/id", Value: `7`, Doc: `{"items":[{"name":"x"},{"name":"y","id":7}]}`, Created: true},
+		{Name: "Append", Op: "set", Pointer: "/items/-", Value: `{}`, Doc: `{"items":[{"name":"x"},{"name":"y"},{}]}`, Created: true},
+		{Name: "Root", Op: "set", Pointer: "", Value: `[]`, Doc: `[]`},
+		{Name: "NoParent", Op: "set", Pointer: "/missing/name", Value: `1`, Err: errPointerNotFound},
+		{Name: "Remove", Op: "remove", Pointer: "/items/0", Doc: `{"items":[{"name":"y"}]}`},
+		{Name: "RemoveMissing", Op: "remove", Pointer: "/items/5", Err: errPointerNotFound},
+		{Name: "Merge", Op: "merge", Pointer: "/items/0", Value: `{"name":null,"id":1}`, Doc: `{"items":[{"id":1},{"name":"y"}]}`},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			var doc, value interface{}
+			require.Nil(t, json.Unmarshal([]byte(`{"items":[{"name":"x"},{"name":"y"}]}`), &doc))
+			if row.Value != "" {
+				require.Nil(t, json.Unmarshal([]byte(row.Value), &value))
+			}
+			tokens, err := parsePointer(row.Pointer)
+			require.Nil(t, err, err)
+
+			created := false
+			switch row.Op {
+			case "set":
+				doc, created, err = setPointer(doc, tokens, value)
+			case "remove":
+				doc, err = removePointer(doc, tokens)
+			case "merge":
+				var target interface{}
+				target, err = getPointer(doc, tokens)
+				require.Nil(t, err, err)
+				doc, _, err = setPointer(doc, tokens, mergePatch(target, value))
+			}
+			require.Equal(t, row.Err, err)
+			if row.Err == nil {
+				require.Equal(t, row.Created, created)
+				data, err := json.Marshal(doc)
+				require.Nil(t, err, err)
+				require.JSONEq(t, row.Doc, string(data))
+			}
+		})
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	table := []struct {
+		Expr   string
+		Values string
+		Err    bool
+	}{
+		{Expr: "$", Values: `[` + testJSONDoc + `]`},
+		{Expr: "$.items[0].name", Values: `["x"]`},
+		{Expr: "$.items[-1].name", Values: `["y"]`},
+		{Expr: "$.items[*].name", Values: `["x","y"]`},
+		{Expr: "$['a/b']", Values: `[1]`},
+		{Expr: "$..name", Values: `["x","y","z"]`},
+		{Expr: "$..tags[0]", Values: `["t1"]`},
+		{Expr: "$.nested.*", Values: `["z"]`},
+		{Expr: "$.missing.name", Values: `[]`},
+		{Expr: "items", Err: true},
+		{Expr: "$.items[", Err: true},
+		{Expr: "$.items[x]", Err: true},
+	}
+
+	for _, row := range table {
+		t.Run(row.Expr, func(t *testing.T) {
+			var doc interface{}
+			require.Nil(t, json.Unmarshal([]byte(testJSONDoc), &doc))
+			steps, err := parseJSONPath(row.Expr)
+			if row.Err {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err, err)
+			data, err := json.Marshal(evalJSONPath(doc, steps))
+			require.Nil(t, err, err)
+			require.JSONEq(t, row.Values, string(data))
+		})
+	}
+}
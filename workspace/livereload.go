@@ -0,0 +1,68 @@
+package workspace
+
+import (
+	"bytes"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// liveReloadScript is the script injected into the HTML documents in the
+// development mode, the page is reloaded when a document of the workspace
+// changes, or the stylesheet is swapped if the document is a linked stylesheet
+const liveReloadScript = `<script>
+(function () {
+	var prefix = %s;
+	var source = new EventSource(prefix + "/` + EventsAPI + `");
+	function swapStylesheet(path) {
+		var swapped = false;
+		var links = document.querySelectorAll("link[rel=stylesheet][href]");
+		for (var i = 0; i < links.length; i++) {
+			var url = new URL(links[i].href, location.href);
+			if (url.origin === location.origin && url.pathname === prefix + path) {
+				url.searchParams.set("livereload", Date.now());
+				links[i].href = url.href;
+				swapped = true;
+			}
+		}
+		return swapped;
+	}
+	function onChange(e) {
+		var event = JSON.parse(e.data);
+		if (event.type === "modify" && /\.css$/.test(event.path) && swapStylesheet(event.path)) {
+			return;
+		}
+		location.reload();
+	}
+	["create", "modify", "delete"].forEach(function (type) {
+		source.addEventListener(type, onChange);
+	});
+})();
+</script>
+`
+
+// isHTML returns true if the content type is HTML
+func isHTML(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/html"
+}
+
+// injectLiveReload returns the HTML document with the live reload script
+// inserted before the closing body tag, or appended if there is none
+func (w *Workspace) injectLiveReload(doc []byte) []byte {
+	script := strings.Replace(liveReloadScript, "%s", strconv.Quote("/"+w.Slug), 1)
+
+	lower := bytes.ToLower(doc)
+	idx := bytes.LastIndex(lower, []byte("</body>"))
+	if idx < 0 {
+		idx = bytes.LastIndex(lower, []byte("</html>"))
+	}
+	if idx < 0 {
+		return append(doc, script...)
+	}
+
+	injected := make([]byte, 0, len(doc)+len(script))
+	injected = append(injected, doc[:idx]...)
+	injected = append(injected, script...)
+	return append(injected, doc[idx:]...)
+}
@@ -0,0 +1,80 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInjectLiveReload(t *testing.T) {
+	w := &Workspace{Slug: "test"}
+
+	table := []struct {
+		Name string
+		Doc  string
+		Head string
+		Tail string
+	}{
+		{Name: "Body", Doc: "<html><body><p>Hi</p></body></html>", Head: "<html><body><p>Hi</p><script>", Tail: "</script>\n</body></html>"},
+		{Name: "UpperBody", Doc: "<HTML><BODY>Hi</BODY></HTML>", Head: "<HTML><BODY>Hi<script>", Tail: "</script>\n</BODY></HTML>"},
+		{Name: "NoBody", Doc: "<html>Hi</html>", Head: "<html>Hi<script>", Tail: "</script>\n</html>"},
+		{Name: "Fragment", Doc: "<p>Hi</p>", Head: "<p>Hi</p><script>", Tail: "</script>\n"},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			injected := string(w.injectLiveReload([]byte(row.Doc)))
+			require.True(t, strings.HasPrefix(injected, row.Head), injected)
+			require.True(t, strings.HasSuffix(injected, row.Tail), injected)
+			require.Contains(t, injected, `var prefix = "/test";`)
+		})
+	}
+}
+
+func TestLiveReload(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"index.html": "<html><body>Index</body></html>",
+		"style.css":  "body {}",
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(root, name), []byte(content), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+
+	config := viper.New()
+	config.Set("dev.liveReload", true)
+
+	table := []struct {
+		Name     string
+		Config   *viper.Viper
+		Path     string
+		Injected bool
+	}{
+		{Name: "HTML", Config: config, Path: "/index.html", Injected: true},
+		{Name: "Index", Config: config, Path: "/", Injected: true},
+		{Name: "CSS", Config: config, Path: "/style.css", Injected: false},
+		{Name: "Disabled", Config: nil, Path: "/index.html", Injected: false},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			w := New(&testMakeshiftd{}, "test", root, row.Config)
+			require.Nil(t, w.err, w.err)
+
+			req := httptest.NewRequest("GET", row.Path, nil)
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, http.StatusOK, res.Code)
+			require.Equal(t, row.Injected, strings.Contains(res.Body.String(), EventsAPI))
+			require.Equal(t, row.Injected, strings.HasSuffix(res.Header().Get("ETag"), `-livereload"`))
+		})
+	}
+}
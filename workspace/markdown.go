@@ -0,0 +1,209 @@
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"gopkg.in/yaml.v2"
+
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// LayoutsDir is the directory of the workspace with the layout templates of
+// the Markdown documents, the layout 'page' is '_layouts/page.html.tmpl'
+const LayoutsDir = "_layouts"
+
+// RawQuery is the query parameter to get the source of a rendered document
+const RawQuery = "raw"
+
+// defaultLayout is the layout of the Markdown documents without a layout in the front matter
+const defaultLayout = "default"
+
+// builtinLayout is the layout if the default layout is not found in the workspace
+var builtinLayout = template.Must(template.New("layout").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Meta.title}}</title>
+</head>
+<body>
+{{.Content}}
+</body>
+</html>
+`))
+
+// markdown renders CommonMark with the GitHub extensions, the code blocks
+// are highlighted and the headings have anchors
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(highlighting.WithStyle("github")),
+	),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(headingAnchors{}, 1000)),
+	),
+)
+
+// headingAnchors appends a link to the anchor of each heading
+type headingAnchors struct{}
+
+func (headingAnchors) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || node.Kind() != ast.KindHeading {
+			return ast.WalkContinue, nil
+		}
+		id, ok := node.AttributeString("id")
+		if !ok {
+			return ast.WalkSkipChildren, nil
+		}
+		link := ast.NewLink()
+		link.Destination = append([]byte("#"), id.([]byte)...)
+		link.SetAttributeString("class", []byte("anchor"))
+		link.AppendChild(link, ast.NewString([]byte("#")))
+		node.AppendChild(node, link)
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// isMarkdown returns true if the file is a Markdown document
+func isMarkdown(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// acceptsHTML returns true if the Accept header of the request includes HTML
+func acceptsHTML(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || mediaType != "text/html" {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// splitFrontMatter returns the YAML front matter and the content of the document,
+// the front matter is delimited by '---' lines at the start of the document
+func splitFrontMatter(doc []byte) (map[string]interface{}, []byte, error) {
+	meta := map[string]interface{}{}
+	rest := bytes.TrimPrefix(doc, []byte("\ufeff"))
+	if !bytes.HasPrefix(rest, []byte("---\n")) && !bytes.HasPrefix(rest, []byte("---\r\n")) {
+		return meta, doc, nil
+	}
+	rest = rest[bytes.IndexByte(rest, '\n')+1:]
+
+	for offset := 0; offset < len(rest); {
+		end := bytes.IndexByte(rest[offset:], '\n')
+		line := rest[offset:]
+		next := len(rest)
+		if end >= 0 {
+			line = rest[offset : offset+end]
+			next = offset + end + 1
+		}
+		if string(bytes.TrimRight(line, "\r")) == "---" {
+			if err := yaml.Unmarshal(rest[:offset], &meta); err != nil {
+				return nil, nil, fmt.Errorf("Front matter invalid: %w", err)
+			}
+			return meta, rest[next:], nil
+		}
+		offset = next
+	}
+	// The front matter is not closed so the document has none
+	return meta, doc, nil
+}
+
+// serveMarkdown serves the Markdown document rendered to HTML with its layout
+func (w *Workspace) serveMarkdown(docPath, docFilePath string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	doc, err := os.ReadFile(docFilePath)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+
+	meta, source, err := splitFrontMatter(doc)
+	if err != nil {
+		log.Debug().Err(err).Msgf("Markdown rendering failed: %s", docFilePath)
+		w.serveError(err, res, req)
+		return
+	}
+	if _, ok := meta["title"]; !ok {
+		meta["title"] = strings.TrimSuffix(filepath.Base(docFilePath), filepath.Ext(docFilePath))
+	}
+
+	content := &bytes.Buffer{}
+	if err := markdown.Convert(source, content); err != nil {
+		log.Warn().Err(err).Msgf("Markdown rendering failed: %s", docFilePath)
+		w.serveError(err, res, req)
+		return
+	}
+
+	docDir, err := filepath.Rel(w.Root, filepath.Dir(docFilePath))
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	data := templateData{
+		Path:    urlpath.Join("/", docPath),
+		Query:   req.URL.Query(),
+		Meta:    meta,
+		Content: template.HTML(content.String()),
+	}
+
+	layout, explicit := meta["layout"].(string)
+	if !explicit {
+		layout = defaultLayout
+	}
+	var out []byte
+	layoutFilePath := filepath.Join(w.Root, LayoutsDir, layout+".html"+TemplateExt)
+	layoutFileInfo, err := os.Stat(layoutFilePath)
+	switch {
+	case err == nil && filepath.Base(layout) == layout:
+		out, err = w.renderTemplate(filepath.ToSlash(docDir), layoutFilePath, layoutFileInfo, data, req)
+	case explicit:
+		err = fmt.Errorf("Layout not found: %s", layout)
+	default:
+		rendered := &bytes.Buffer{}
+		err = builtinLayout.Execute(rendered, data)
+		out = rendered.Bytes()
+	}
+	if err != nil {
+		log.Warn().Err(err).Msgf("Markdown layout rendering failed: %s", docFilePath)
+		w.serveError(err, res, req)
+		return
+	}
+
+	if w.liveReload {
+		out = w.injectLiveReload(out)
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(out)))
+	res.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(res, req, docFilePath, time.Time{}, bytes.NewReader(out))
+}
@@ -0,0 +1,89 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	table := []struct {
+		Name    string
+		Doc     string
+		Meta    map[string]interface{}
+		Content string
+	}{
+		{Name: "None", Doc: "# Title\n", Meta: map[string]interface{}{}, Content: "# Title\n"},
+		{Name: "FrontMatter", Doc: "---\ntitle: Notes\nlayout: page\n---\n# Title\n", Meta: map[string]interface{}{"title": "Notes", "layout": "page"}, Content: "# Title\n"},
+		{Name: "CRLF", Doc: "---\r\ntitle: Notes\r\n---\r\nText", Meta: map[string]interface{}{"title": "Notes"}, Content: "Text"},
+		{Name: "Unclosed", Doc: "---\ntitle: Notes\n", Meta: map[string]interface{}{}, Content: "---\ntitle: Notes\n"},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			meta, content, err := splitFrontMatter([]byte(row.Doc))
+			require.Nil(t, err, err)
+			require.Equal(t, row.Meta, meta)
+			require.Equal(t, row.Content, string(content))
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"_layouts/page.html.tmpl": `<main title="{{.Meta.title}}">{{.Content}}</main>`,
+		"notes.md":                "# Notes\n\n```go\nfunc main() {}\n```\n",
+		"page.md":                 "---\ntitle: Page\nlayout: page\n---\n## Section\n",
+		"broken.md":               "---\nlayout: missing\n---\nText\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		require.Nil(t, err, err)
+		err = os.WriteFile(path, []byte(content), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+
+	w := New(&testMakeshiftd{}, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+
+	table := []struct {
+		Path     string
+		Accept   string
+		Code     int
+		Contains []string
+	}{
+		{Path: "/notes.md", Accept: "text/html,*/*;q=0.8", Code: http.StatusOK, Contains: []string{
+			"<title>notes</title>",
+			`<h1 id="notes">Notes<a href="#notes" class="anchor">#</a></h1>`,
+			`<span style="color:#000;font-weight:bold">func</span>`,
+		}},
+		{Path: "/page.md", Accept: "text/html", Code: http.StatusOK, Contains: []string{
+			`<main title="Page"><h2 id="section">Section`,
+		}},
+		{Path: "/notes.md?raw", Accept: "text/html", Code: http.StatusOK, Contains: []string{"# Notes\n"}},
+		{Path: "/notes.md", Accept: "*/*", Code: http.StatusOK, Contains: []string{"# Notes\n"}},
+		{Path: "/notes.md", Accept: "text/html;q=0", Code: http.StatusOK, Contains: []string{"# Notes\n"}},
+		{Path: "/broken.md", Accept: "text/html", Code: http.StatusInternalServerError},
+	}
+
+	for _, row := range table {
+		t.Run(row.Path+":"+row.Accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", row.Path, nil)
+			req.Header.Set("Accept", row.Accept)
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code)
+			require.Equal(t, "Accept", res.Header().Get("Vary"))
+			for _, contains := range row.Contains {
+				require.Contains(t, res.Body.String(), contains)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package workspace
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+)
+
+// allowedMethods returns the methods allowed for the document by the type
+// of the resource and by the access rules for the principal
+func (w *Workspace) allowedMethods(p auth.Principal, docPath string, exec bool) ([]string, error) {
+	var methods []string
+	if exec {
+		exeDocPath, _, err := w.findExecDoc(docPath)
+		if err != nil {
+			return nil, err
+		}
+		if exeDocPath != "" {
+			methods = execMethods
+		}
+	} else {
+		filePath := filepath.Join(w.Root, filepath.FromSlash(docPath))
+		docFilePath, docFileInfo, err := w.resolveDocFile(docPath)
+		switch {
+		case err != nil && os.IsNotExist(err):
+			if docFilePath == filePath {
+				methods = []string{"POST", "PUT"}
+			}
+		case err != nil:
+			return nil, err
+		case docFileInfo.IsDir():
+			// A directory without an index document has no content unless it is
+			// a collection, the documents posted to a directory are created in it
+			if w.collections != nil {
+				methods = []string{"GET", "HEAD"}
+			}
+			methods = append(methods, "POST")
+		case docFilePath != filePath:
+			methods = []string{"GET", "HEAD"}
+			if filepath.Dir(docFilePath) == filePath {
+				methods = append(methods, "POST")
+			}
+		case strings.EqualFold(filepath.Ext(docFilePath), ".json"):
+			methods = []string{"GET", "HEAD", "PUT", "PATCH"}
+		default:
+			methods = []string{"GET", "HEAD", "PUT"}
+		}
+		// A document rendered from a template cannot be copied
+		if err == nil && docPath != "" && (docFilePath == filePath || !strings.HasSuffix(docFilePath, TemplateExt)) {
+			methods = append(methods, "COPY", "MOVE")
+		}
+		if w.locks != nil {
+			if err == nil {
+				methods = append(methods, "DELETE", "PROPFIND", "PROPPATCH", "LOCK", "UNLOCK")
+			} else {
+				methods = append(methods, "MKCOL", "LOCK")
+			}
+		}
+	}
+
+	allowed := []string{}
+	for _, method := range methods {
+		ok, err := w.authorize(p, accessMethod(method, exec), docPath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			allowed = append(allowed, method)
+		}
+	}
+	return append(allowed, "OPTIONS"), nil
+}
+
+// serveOptions serves the methods allowed for the document
+func (w *Workspace) serveOptions(docPath string, exec bool, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	allowed, err := w.allowedMethods(auth.Ctx(req.Context()), docPath, exec)
+	if err != nil {
+		log.Warn().Err(err).Msg("Allowed methods evaluation failed")
+		w.serveError(err, res, req)
+		return
+	}
+	if w.locks != nil && !exec {
+		res.Header().Set("DAV", "1, 2")
+		res.Header().Set("MS-Author-Via", "DAV")
+	}
+	res.Header().Set("Allow", strings.Join(allowed, ", "))
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// serveMethodNotAllowed serves a method not allowed error with the
+// methods allowed for the document
+func (w *Workspace) serveMethodNotAllowed(docPath string, exec bool, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	allowed, err := w.allowedMethods(auth.Ctx(req.Context()), docPath, exec)
+	if err != nil {
+		log.Warn().Err(err).Msg("Allowed methods evaluation failed")
+	} else {
+		res.Header().Set("Allow", strings.Join(allowed, ", "))
+	}
+	w.serveError(http.StatusMethodNotAllowed, res, req)
+}
@@ -0,0 +1,77 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	root := t.TempDir()
+
+	config := viper.New()
+	config.Set("access", map[string]interface{}{
+		"rules": []interface{}{
+			map[string]interface{}{
+				"paths":      []string{"readonly.txt"},
+				"methods":    []string{"PUT"},
+				"principals": []string{"*"},
+				"effect":     "deny",
+			},
+		},
+	})
+
+	for _, name := range []string{"doc.txt", "readonly.txt", "script.go"} {
+		err := os.WriteFile(filepath.Join(root, name), []byte("package main\n"), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+	err := os.MkdirAll(filepath.Join(root, "empty"), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.MkdirAll(filepath.Join(root, "site"), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<p>Site</p>"), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	table := []struct {
+		Method string
+		Path   string
+		Code   int
+		Allow  string
+	}{
+		{Method: "OPTIONS", Path: "/doc.txt", Code: http.StatusNoContent, Allow: "GET, HEAD, PUT, COPY, MOVE, OPTIONS"},
+		{Method: "OPTIONS", Path: "/readonly.txt", Code: http.StatusNoContent, Allow: "GET, HEAD, COPY, MOVE, OPTIONS"},
+		{Method: "OPTIONS", Path: "/new.txt", Code: http.StatusNoContent, Allow: "POST, PUT, OPTIONS"},
+		{Method: "OPTIONS", Path: "/empty", Code: http.StatusNoContent, Allow: "POST, COPY, MOVE, OPTIONS"},
+		{Method: "OPTIONS", Path: "/site", Code: http.StatusNoContent, Allow: "GET, HEAD, POST, COPY, MOVE, OPTIONS"},
+		{Method: "OPTIONS", Path: "/!script", Code: http.StatusNoContent, Allow: "GET, HEAD, POST, OPTIONS"},
+		{Method: "POST", Path: "/empty", Code: http.StatusCreated},
+		{Method: "HEAD", Path: "/doc.txt", Code: http.StatusOK},
+		{Method: "HEAD", Path: "/!script", Code: http.StatusOK},
+		{Method: "DELETE", Path: "/doc.txt", Code: http.StatusMethodNotAllowed, Allow: "GET, HEAD, PUT, COPY, MOVE, OPTIONS"},
+		{Method: "PUT", Path: "/!script", Code: http.StatusMethodNotAllowed, Allow: "GET, HEAD, POST, OPTIONS"},
+	}
+
+	for _, row := range table {
+		t.Run(row.Method+":"+row.Path, func(t *testing.T) {
+			req := httptest.NewRequest(row.Method, row.Path, nil)
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code)
+			require.Equal(t, row.Allow, res.Header().Get("Allow"))
+			require.Empty(t, res.Body.String())
+		})
+	}
+
+	// The document posted to the directory is created in the directory
+	entries, err := os.ReadDir(filepath.Join(root, "empty"))
+	require.Nil(t, err, err)
+	require.Len(t, entries, 1)
+}
@@ -0,0 +1,233 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sync"
+)
+
+// UsageAPI is the segment of the workspace API reporting the usage
+const UsageAPI = "_usage"
+
+var errQuotaExceeded = errors.New("Workspace quota exceeded")
+
+// Usage is the storage used by the documents of a workspace
+type Usage struct {
+	Bytes    int64 `json:"bytes"`
+	Files    int64 `json:"files"`
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+	MaxFiles int64 `json:"maxFiles,omitempty"`
+}
+
+// quota tracks the usage of a workspace against the configured limits,
+// a limit of zero is unlimited
+type quota struct {
+	maxBytes int64
+	maxFiles int64
+
+	mtx   sync.Mutex
+	bytes int64
+	files int64
+}
+
+// Usage returns the current usage of the workspace
+func (w *Workspace) Usage() Usage {
+	w.quota.mtx.Lock()
+	defer w.quota.mtx.Unlock()
+	return Usage{
+		Bytes:    w.quota.bytes,
+		Files:    w.quota.files,
+		MaxBytes: w.quota.maxBytes,
+		MaxFiles: w.quota.maxFiles,
+	}
+}
+
+// reconcileUsage sets the usage of the workspace from the files in the root
+// directory, the bytes of the history are counted but not the files
+func (w *Workspace) reconcileUsage() error {
+	bytes, files, err := treeUsage(w.Root)
+	if err != nil {
+		return err
+	}
+	if w.history != nil {
+		historyBytes, _, err := treeUsage(w.statePath(historyDir))
+		if err != nil {
+			return err
+		}
+		bytes += historyBytes
+	}
+
+	w.quota.mtx.Lock()
+	defer w.quota.mtx.Unlock()
+	w.quota.bytes = bytes
+	w.quota.files = files
+	return nil
+}
+
+// treeUsage returns the bytes and number of the files in the file tree at the path,
+// the search index is maintained by the server and is not counted, nor is the
+// history which is counted separately
+func treeUsage(root string) (int64, int64, error) {
+	var bytes, files int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (path == filepath.Join(root, StateDir, searchDir) || path == filepath.Join(root, StateDir, historyDir)) {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		bytes += info.Size()
+		files++
+		return nil
+	})
+	return bytes, files, err
+}
+
+// addUsage adds the change in bytes and files to the usage of the workspace
+// if an increase of the bytes or files would not exceed the limits
+func (w *Workspace) addUsage(bytes, files int64) error {
+	w.quota.mtx.Lock()
+	defer w.quota.mtx.Unlock()
+	if files > 0 && w.quota.maxFiles > 0 && w.quota.files+files > w.quota.maxFiles {
+		return errQuotaExceeded
+	}
+	if bytes > 0 && w.quota.maxBytes > 0 && w.quota.bytes+bytes > w.quota.maxBytes {
+		return errQuotaExceeded
+	}
+	w.quota.bytes += bytes
+	w.quota.files += files
+	return nil
+}
+
+// updateUsage adds the change in bytes and files to the usage of the workspace
+func (w *Workspace) updateUsage(bytes, files int64) {
+	w.quota.mtx.Lock()
+	defer w.quota.mtx.Unlock()
+	w.quota.bytes += bytes
+	w.quota.files += files
+}
+
+// reservation is the usage reserved for a document being written, so that
+// concurrent writes cannot together exceed the quota, the bytes of the
+// document being replaced are reused by the document
+type reservation struct {
+	w        *Workspace
+	replaced int64
+	bytes    int64
+	files    int64
+}
+
+// reserveUsage reserves the usage of a document of the size, or -1 if the
+// size is unknown, replacing a document of the replaced bytes, the file is
+// reserved if the document is new. The reservation must be released once
+// the document is written or the write has failed.
+func (w *Workspace) reserveUsage(replacedBytes, size int64, newFile bool) (*reservation, error) {
+	r := &reservation{w: w, replaced: replacedBytes}
+	if newFile {
+		r.files = 1
+	}
+	if size > replacedBytes {
+		r.bytes = size - replacedBytes
+	}
+	if err := w.addUsage(r.bytes, r.files); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reserve increases the reservation to the size of the document
+func (r *reservation) reserve(size int64) error {
+	bytes := size - r.replaced - r.bytes
+	if bytes <= 0 {
+		return nil
+	}
+	if err := r.w.addUsage(bytes, 0); err != nil {
+		return err
+	}
+	r.bytes += bytes
+	return nil
+}
+
+// release replaces the reservation with the change in bytes and files of the
+// usage from writing the document, which is zero if the write has failed
+func (r *reservation) release(bytes, files int64) {
+	r.w.updateUsage(bytes-r.bytes, files-r.files)
+	r.bytes = 0
+	r.files = 0
+}
+
+// limit returns the body with the bytes read reserved,
+// reading beyond the quota returns errQuotaExceeded
+func (r *reservation) limit(body io.Reader) io.Reader {
+	return &quotaReader{r: body, reservation: r}
+}
+
+type quotaReader struct {
+	r           io.Reader
+	reservation *reservation
+	read        int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.read += int64(n)
+	if reserveErr := q.reservation.reserve(q.read); reserveErr != nil {
+		return n, reserveErr
+	}
+	return n, err
+}
+
+// serveWriteError serves the error from writing the request body to a document
+func (w *Workspace) serveWriteError(err error, res http.ResponseWriter, req *http.Request) {
+	var maxBytesErr *http.MaxBytesError
+	var schemaErr *schemaError
+	switch {
+	case errors.As(err, &schemaErr):
+		w.serveSchemaError(schemaErr, res, req)
+	case errors.As(err, &maxBytesErr):
+		w.serveError(http.StatusRequestEntityTooLarge, res, req)
+	case errors.Is(err, errQuotaExceeded):
+		w.serveError(http.StatusInsufficientStorage, res, req)
+	case errors.Is(err, fs.ErrExist):
+		w.serveError(http.StatusConflict, res, req)
+	default:
+		w.serveError(err, res, req)
+	}
+}
+
+// serveUsage serves the usage of the workspace
+func (w *Workspace) serveUsage(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET", "HEAD":
+	case "OPTIONS":
+		res.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		res.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		res.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.serveError(http.StatusMethodNotAllowed, res, req)
+		return
+	}
+
+	data, err := json.Marshal(w.Usage())
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		res.Write(data)
+	}
+}
@@ -0,0 +1,154 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	root := t.TempDir()
+
+	config := viper.New()
+	config.Set("limits.maxBodySize", "8")
+	config.Set("quota.maxBytes", "20")
+	config.Set("quota.maxFiles", 3)
+
+	err := os.WriteFile(filepath.Join(root, "existing.txt"), []byte("0123456789"), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+	require.Equal(t, Usage{Bytes: 10, Files: 1, MaxBytes: 20, MaxFiles: 3}, w.Usage())
+
+	table := []struct {
+		Name    string
+		Method  string
+		Path    string
+		Body    string
+		Chunked bool
+		Code    int
+		Bytes   int64
+		Files   int64
+	}{
+		{Name: "BodyTooLarge", Method: "PUT", Path: "/large.txt", Body: strings.Repeat("x", 9), Code: http.StatusRequestEntityTooLarge, Bytes: 10, Files: 1},
+		{Name: "BodyTooLargeChunked", Method: "PUT", Path: "/large.txt", Body: strings.Repeat("x", 9), Chunked: true, Code: http.StatusRequestEntityTooLarge, Bytes: 10, Files: 1},
+		{Name: "Put", Method: "PUT", Path: "/new.txt", Body: "01234", Code: http.StatusCreated, Bytes: 15, Files: 2},
+		{Name: "PutExceedsBytes", Method: "PUT", Path: "/other.txt", Body: "012345", Code: http.StatusInsufficientStorage, Bytes: 15, Files: 2},
+		{Name: "PutExceedsBytesChunked", Method: "PUT", Path: "/other.txt", Body: "012345", Chunked: true, Code: http.StatusInsufficientStorage, Bytes: 15, Files: 2},
+		{Name: "Replace", Method: "PUT", Path: "/existing.txt", Body: "0123", Code: http.StatusOK, Bytes: 9, Files: 2},
+		{Name: "Post", Method: "POST", Path: "/posted.txt", Body: "0", Code: http.StatusCreated, Bytes: 10, Files: 3},
+		{Name: "PostExceedsFiles", Method: "POST", Path: "/file-*.txt", Body: "0", Code: http.StatusInsufficientStorage, Bytes: 10, Files: 3},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			req := httptest.NewRequest(row.Method, row.Path, strings.NewReader(row.Body))
+			if row.Chunked {
+				req.ContentLength = -1
+			}
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code)
+
+			usage := w.Usage()
+			require.Equal(t, row.Bytes, usage.Bytes)
+			require.Equal(t, row.Files, usage.Files)
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "existing.txt"))
+	require.Nil(t, err, err)
+	require.Equal(t, "0123", string(data))
+
+	err = w.reconcileUsage()
+	require.Nil(t, err, err)
+	require.Equal(t, Usage{Bytes: 10, Files: 3, MaxBytes: 20, MaxFiles: 3}, w.Usage())
+
+	req := httptest.NewRequest("GET", "/"+UsageAPI, nil)
+	res := httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	usage := Usage{}
+	err = json.Unmarshal(res.Body.Bytes(), &usage)
+	require.Nil(t, err, err)
+	require.Equal(t, w.Usage(), usage)
+}
+
+// barrierReader returns the first half of the body then waits
+// for the first halves of all the bodies to have been read
+type barrierReader struct {
+	body    string
+	barrier *sync.WaitGroup
+	read    int
+}
+
+func (r *barrierReader) Read(p []byte) (int, error) {
+	switch r.read {
+	case 0:
+		r.read = copy(p, r.body[:len(r.body)/2])
+		r.barrier.Done()
+		return r.read, nil
+	case len(r.body):
+		return 0, io.EOF
+	}
+	r.barrier.Wait()
+	n := copy(p, r.body[r.read:])
+	r.read += n
+	return n, nil
+}
+
+func TestQuotaConcurrent(t *testing.T) {
+	root := t.TempDir()
+
+	config := viper.New()
+	config.Set("quota.maxBytes", "100")
+
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	const count = 10
+	barrier := &sync.WaitGroup{}
+	barrier.Add(count)
+	codes := make([]int, count)
+	done := &sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			body := &barrierReader{body: strings.Repeat("x", 30), barrier: barrier}
+			req := httptest.NewRequest("PUT", fmt.Sprintf("/doc%d.txt", i), body)
+			req.ContentLength = -1
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			codes[i] = res.Code
+		}(i)
+	}
+	done.Wait()
+
+	created := 0
+	for _, code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else {
+			require.Equal(t, http.StatusInsufficientStorage, code)
+		}
+	}
+	require.Greater(t, created, 0)
+	require.LessOrEqual(t, created*30, 100)
+	require.Equal(t, Usage{Bytes: int64(created * 30), Files: int64(created), MaxBytes: 100}, w.Usage())
+
+	err := w.reconcileUsage()
+	require.Nil(t, err, err)
+	require.Equal(t, Usage{Bytes: int64(created * 30), Files: int64(created), MaxBytes: 100}, w.Usage())
+}
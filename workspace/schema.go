@@ -0,0 +1,269 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// ValidateAPI is the segment of the workspace API validating
+// a document without writing it
+const ValidateAPI = "_validate"
+
+// SchemaDir is the hidden directory of the workspace with the JSON Schemas,
+// the schema '_schema/dir/schema.json' applies to the JSON documents of the
+// directory 'dir' and its subdirectories unless a nearer schema applies
+const SchemaDir = "_schema"
+
+// SchemaFileName is the name of the schema of a directory in the schema directory
+const SchemaFileName = "schema.json"
+
+// SchemaRule pairs document path patterns with the path of the JSON Schema,
+// relative to the root of the workspace, of the documents. Paths may use
+// '**' to match any number of segments.
+type SchemaRule struct {
+	Paths  []string `json:"paths" mapstructure:"paths"`
+	Schema string   `json:"schema" mapstructure:"schema"`
+}
+
+// schemaError is the error if a document does not satisfy its schema
+type schemaError struct {
+	Valid  bool                    `json:"valid"`
+	Errors []jsonschema.BasicError `json:"errors"`
+}
+
+func (e *schemaError) Error() string {
+	return fmt.Sprintf("Document invalid: %d violations", len(e.Errors))
+}
+
+// schemas are the compiled schemas of the workspace, a schema is
+// compiled again if its file changes
+type schemas struct {
+	rules    []SchemaRule
+	mtx      sync.Mutex
+	compiled map[string]compiledSchema
+}
+
+type compiledSchema struct {
+	etag   string
+	schema *jsonschema.Schema
+}
+
+// schemaFile returns the file path of the schema of the document, or an
+// empty path if no schema applies, the rules of the configuration apply
+// before the schemas of the schema directory
+func (w *Workspace) schemaFile(docPath string) (string, error) {
+	docPath = strings.Trim(docPath, "/")
+	for _, rule := range w.schemas.rules {
+		for _, pattern := range rule.Paths {
+			ok, err := urlpath.Match(pattern, docPath)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				return filepath.Join(w.Root, filepath.FromSlash(rule.Schema)), nil
+			}
+		}
+	}
+
+	if !strings.EqualFold(filepath.Ext(docPath), ".json") {
+		return "", nil
+	}
+	dir, _ := urlpath.Split(docPath)
+	for {
+		dir = strings.Trim(dir, "/")
+		path := filepath.Join(w.Root, SchemaDir, filepath.FromSlash(dir), SchemaFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+		if dir == "" {
+			return "", nil
+		}
+		dir, _ = urlpath.Split(dir)
+	}
+}
+
+// schema returns the compiled schema of the document, or nil if no schema applies
+func (w *Workspace) schema(docPath string) (*jsonschema.Schema, error) {
+	schemaFilePath, err := w.schemaFile(docPath)
+	if err != nil || schemaFilePath == "" {
+		return nil, err
+	}
+	info, err := os.Stat(schemaFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("Schema not found: %s: %w", docPath, err)
+	}
+
+	w.schemas.mtx.Lock()
+	defer w.schemas.mtx.Unlock()
+
+	etag := docETag(info)
+	if compiled, ok := w.schemas.compiled[schemaFilePath]; ok && compiled.etag == etag {
+		return compiled.schema, nil
+	}
+
+	absFilePath, err := filepath.Abs(schemaFilePath)
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.LoadURL = w.loadSchemaURL
+	schemaURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(absFilePath)}).String()
+	schema, err := compiler.Compile(schemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("Schema invalid: %s: %w", schemaFilePath, err)
+	}
+
+	if w.schemas.compiled == nil {
+		w.schemas.compiled = map[string]compiledSchema{}
+	}
+	w.schemas.compiled[schemaFilePath] = compiledSchema{etag: etag, schema: schema}
+	return schema, nil
+}
+
+// loadSchemaURL loads the schemas referenced by a schema,
+// only the files of the workspace may be referenced
+func (w *Workspace) loadSchemaURL(s string) (io.ReadCloser, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(w.Root)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Clean(filepath.FromSlash(u.Path))
+	if u.Scheme != "file" || !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return nil, fmt.Errorf("Schema reference not allowed: %s", s)
+	}
+	return os.Open(path)
+}
+
+// validateDoc returns a schema error if the document does not satisfy its schema
+func (w *Workspace) validateDoc(docPath string, data []byte) error {
+	schema, err := w.schema(docPath)
+	if err != nil || schema == nil {
+		return err
+	}
+	value, err := decodeJSON(data)
+	if err != nil {
+		return &schemaError{Errors: []jsonschema.BasicError{{
+			KeywordLocation:  "",
+			InstanceLocation: "",
+			Error:            fmt.Sprintf("document is not valid JSON: %s", err),
+		}}}
+	}
+	if err := schema.Validate(value); err != nil {
+		if validationErr, ok := err.(*jsonschema.ValidationError); ok {
+			return &schemaError{Errors: validationErr.BasicOutput().Errors}
+		}
+		return err
+	}
+	return nil
+}
+
+// validateBody returns the body of the document after validating it
+// if a schema applies, otherwise the body is returned as is
+func (w *Workspace) validateBody(docPath string, body io.Reader) (io.Reader, error) {
+	schema, err := w.schema(docPath)
+	if err != nil || schema == nil {
+		return body, err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.validateDoc(docPath, data); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// validateFile returns a schema error if the file does not satisfy the schema of the document
+func (w *Workspace) validateFile(docPath, filePath string) error {
+	schema, err := w.schema(docPath)
+	if err != nil || schema == nil {
+		return err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return w.validateDoc(docPath, data)
+}
+
+// validateTree returns a schema error if a file of the tree at the file path
+// does not satisfy the schema of the document it is copied to at the path
+func (w *Workspace) validateTree(filePath string, info os.FileInfo, docPath string) error {
+	if !info.IsDir() {
+		return w.validateFile(docPath, filePath)
+	}
+	return filepath.WalkDir(filePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(filePath, path)
+		if err != nil {
+			return err
+		}
+		return w.validateFile(urlpath.Join(docPath, filepath.ToSlash(rel)), path)
+	})
+}
+
+// serveSchemaError serves the violations of the schema
+func (w *Workspace) serveSchemaError(err *schemaError, res http.ResponseWriter, req *http.Request) {
+	data, marshalErr := json.Marshal(err)
+	if marshalErr != nil {
+		w.serveError(marshalErr, res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusUnprocessableEntity)
+	res.Write(data)
+}
+
+// serveValidate validates the body as the document of the 'path'
+// query without writing it, the violations are served if invalid
+func (w *Workspace) serveValidate(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "POST":
+	case "OPTIONS":
+		res.Header().Set("Allow", "POST, OPTIONS")
+		res.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		res.Header().Set("Allow", "POST, OPTIONS")
+		w.serveError(http.StatusMethodNotAllowed, res, req)
+		return
+	}
+
+	segments, exec, ok := docSegments(req.URL.Query().Get("path"))
+	if !ok || exec || len(segments) == 0 {
+		w.serveError(http.StatusBadRequest, res, req)
+		return
+	}
+	data, err := io.ReadAll(req.Body)
+	if err == nil {
+		err = w.validateDoc(urlpath.Join(segments...), data)
+	}
+	if err != nil {
+		w.serveWriteError(err, res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(`{"valid":true,"errors":[]}`))
+}
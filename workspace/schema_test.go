@@ -0,0 +1,125 @@
+package workspace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"_schema/tasks/schema.json": `{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"type": "object",
+			"required": ["title"],
+			"properties": {
+				"title": {"type": "string"},
+				"owner": {"$ref": "../person.json"}
+			}
+		}`,
+		"_schema/person.json": `{"type": "object", "required": ["name"]}`,
+		"schemas/note.json":   `{"type": "object", "properties": {"text": {"type": "string", "maxLength": 5}}}`,
+		"schemas/remote.json": `{"$ref": "https://example.com/schema.json"}`,
+		"tasks/a.json":        `{"title":"a"}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		require.Nil(t, err, err)
+		err = os.WriteFile(path, []byte(content), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+
+	config := viper.New()
+	config.Set("schemas", []interface{}{
+		map[string]interface{}{"paths": []string{"notes/**"}, "schema": "schemas/note.json"},
+		map[string]interface{}{"paths": []string{"remote/*"}, "schema": "schemas/remote.json"},
+	})
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	table := []struct {
+		Method string
+		Path   string
+		Body   string
+		Code   int
+		Errors []string
+	}{
+		{Method: "PUT", Path: "/tasks/b.json", Body: `{"title":"b"}`, Code: http.StatusCreated},
+		{Method: "PUT", Path: "/tasks/b.json", Body: `{"title":1}`, Code: http.StatusUnprocessableEntity, Errors: []string{"/title"}},
+		{Method: "PUT", Path: "/tasks/sub/c.json", Body: `{"title":"c","owner":{}}`, Code: http.StatusUnprocessableEntity, Errors: []string{"/owner"}},
+		{Method: "PUT", Path: "/tasks/d.json", Body: `{`, Code: http.StatusUnprocessableEntity, Errors: []string{""}},
+		{Method: "PUT", Path: "/tasks/e.txt", Body: `{`, Code: http.StatusCreated},
+		{Method: "POST", Path: "/tasks/*.json", Body: `{}`, Code: http.StatusUnprocessableEntity, Errors: []string{""}},
+		{Method: "POST", Path: "/tasks/*.json", Body: `{"title":"f"}`, Code: http.StatusCreated},
+		{Method: "PUT", Path: "/notes/x/y.txt", Body: `{"text":"too long"}`, Code: http.StatusUnprocessableEntity, Errors: []string{"/text"}},
+		{Method: "PUT", Path: "/tasks/a.json?ptr=/title", Body: `2`, Code: http.StatusUnprocessableEntity, Errors: []string{"/title"}},
+		{Method: "PUT", Path: "/tasks/a.json?ptr=/title", Body: `"a2"`, Code: http.StatusNoContent},
+		{Method: "PUT", Path: "/remote/a.json", Body: `{}`, Code: http.StatusInternalServerError},
+		{Method: "POST", Path: "/" + ValidateAPI + "?path=/tasks/g.json", Body: `{"title":"g"}`, Code: http.StatusOK},
+		{Method: "POST", Path: "/" + ValidateAPI + "?path=/tasks/g.json", Body: `[]`, Code: http.StatusUnprocessableEntity, Errors: []string{""}},
+		{Method: "POST", Path: "/" + ValidateAPI, Body: `{}`, Code: http.StatusBadRequest},
+	}
+
+	for _, row := range table {
+		t.Run(row.Method+":"+row.Path, func(t *testing.T) {
+			req := httptest.NewRequest(row.Method, row.Path, strings.NewReader(row.Body))
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code, res.Body.String())
+			if row.Errors != nil {
+				result := schemaError{}
+				err := json.Unmarshal(res.Body.Bytes(), &result)
+				require.Nil(t, err, err)
+				require.False(t, result.Valid)
+				locations := map[string]bool{}
+				for _, e := range result.Errors {
+					locations[e.InstanceLocation] = true
+				}
+				for _, location := range row.Errors {
+					require.True(t, locations[location], res.Body.String())
+				}
+			}
+		})
+	}
+
+	// The invalid documents are not written
+	_, err := os.Stat(filepath.Join(root, "tasks", "d.json"))
+	require.True(t, os.IsNotExist(err))
+	data, err := os.ReadFile(filepath.Join(root, "tasks", "b.json"))
+	require.Nil(t, err, err)
+	require.Equal(t, `{"title":"b"}`, string(data))
+
+	// The copied and moved documents are validated at the destination,
+	// also by the WebDAV handler
+	webdavConfig := viper.New()
+	webdavConfig.Set("webdav.enabled", true)
+	webdavW := New(&testMakeshiftd{}, "webdav", root, webdavConfig)
+	require.Nil(t, webdavW.err, webdavW.err)
+
+	err = os.WriteFile(filepath.Join(root, "loose.json"), []byte(`{"nope":1}`), os.ModePerm)
+	require.Nil(t, err, err)
+
+	for _, w := range []*Workspace{w, webdavW} {
+		for _, method := range []string{"COPY", "MOVE"} {
+			t.Run(w.Slug+":"+method, func(t *testing.T) {
+				req := httptest.NewRequest(method, "/loose.json", nil)
+				req.Header.Set("Destination", "/"+w.Slug+"/tasks/x.json")
+				res := httptest.NewRecorder()
+				w.ServeHTTP(res, req)
+				require.Equal(t, http.StatusUnprocessableEntity, res.Code, res.Body.String())
+				_, err := os.Stat(filepath.Join(root, "tasks", "x.json"))
+				require.True(t, os.IsNotExist(err))
+			})
+		}
+	}
+}
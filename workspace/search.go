@@ -0,0 +1,712 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"html"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// SearchAPI is the segment of the workspace API searching the documents
+const SearchAPI = "_search"
+
+// searchDir is the directory of the workspace state in which the search index is persisted
+const searchDir = "search"
+
+// searchIndexFile is the file of the persisted search index
+const searchIndexFile = "index.gob"
+
+// maxSearchDocSize is the size of the largest document indexed
+const maxSearchDocSize = 4 << 20
+
+// searchSaveDelay is the delay before the changes of the index are persisted
+const searchSaveDelay = 5 * time.Second
+
+// maxSearchPending is the number of changed documents waiting to be indexed,
+// the index is reconciled with the files if more documents are changed
+const maxSearchPending = 1024
+
+// Limits of the number of results of a page of search results
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 100
+)
+
+// Parameters of the BM25 ranking of the search results
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// searchSnippetSize is the approximate number of characters of the snippets of the results
+const searchSnippetSize = 160
+
+// SearchResult is a document matching a search query, the snippet
+// is HTML with the matching terms in 'mark' elements
+type SearchResult struct {
+	Path    string  `json:"path"`
+	Title   string  `json:"title,omitempty"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchResults is a page of the results of a search query
+type SearchResults struct {
+	Total   int            `json:"total"`
+	Results []SearchResult `json:"results"`
+}
+
+// searchDoc is an indexed document with the frequencies of its terms
+type searchDoc struct {
+	ETag   string
+	Title  string
+	Length int
+	Terms  map[string]int
+}
+
+// search is the full text index of the documents of the workspace, the index
+// is built in the background from the first search and is then updated by the
+// change events, the update mutex serializes the indexing of the documents and
+// the save mutex serializes the saving of the index
+type search struct {
+	update      sync.Mutex
+	save        sync.Mutex
+	mtx         sync.RWMutex
+	built       bool
+	ready       bool
+	docs        map[string]searchDoc
+	postings    map[string]map[string]int
+	totalLength int
+	pending     map[string]bool
+	changed     chan struct{}
+	stale       bool
+	reconciling bool
+	saveTimer   *time.Timer
+}
+
+// searchable returns true if the text of the document can be indexed,
+// the documents of the hidden directories are not indexed
+func searchable(docPath string) bool {
+	for _, segment := range strings.Split(docPath, "/") {
+		if strings.HasPrefix(segment, ".") || strings.HasPrefix(segment, "_") {
+			return false
+		}
+	}
+	switch strings.ToLower(filepath.Ext(docPath)) {
+	case ".txt", ".html", ".htm", ".md", ".markdown", ".json":
+		return true
+	}
+	return false
+}
+
+// tokenSpan is a term of a text with its position
+type tokenSpan struct {
+	term  string
+	start int
+	end   int
+}
+
+// tokenize returns the lower case terms of the text, a term is a
+// sequence of letters and digits of at least two characters
+func tokenize(text string) []tokenSpan {
+	tokens := []tokenSpan{}
+	start := -1
+	for idx, r := range text + " " {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = idx
+		case !word && start >= 0:
+			if utf8.RuneCountInString(text[start:idx]) >= 2 {
+				tokens = append(tokens, tokenSpan{term: strings.ToLower(text[start:idx]), start: start, end: idx})
+			}
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// extractText returns the title and the text of the document
+func extractText(docPath string, data []byte) (string, string) {
+	switch strings.ToLower(filepath.Ext(docPath)) {
+	case ".html", ".htm":
+		return extractHTMLText(data)
+	case ".md", ".markdown":
+		title := ""
+		meta, source, err := splitFrontMatter(data)
+		if err == nil {
+			data = source
+			title, _ = meta["title"].(string)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if title != "" {
+				break
+			}
+			if strings.HasPrefix(line, "# ") {
+				title = strings.TrimSpace(line[2:])
+			}
+		}
+		return title, string(data)
+	case ".json":
+		value, err := decodeJSON(data)
+		if err != nil {
+			return "", ""
+		}
+		strs := []string{}
+		var collect func(v interface{})
+		collect = func(v interface{}) {
+			switch v := v.(type) {
+			case string:
+				strs = append(strs, v)
+			case []interface{}:
+				for _, elem := range v {
+					collect(elem)
+				}
+			case map[string]interface{}:
+				for _, key := range sortedKeys(v) {
+					collect(v[key])
+				}
+			}
+		}
+		collect(value)
+		return "", strings.Join(strs, "\n")
+	}
+	return "", string(data)
+}
+
+// extractHTMLText returns the title and the text of the HTML document
+// without the tags and the content of the scripts and the styles
+func extractHTMLText(data []byte) (string, string) {
+	text := &strings.Builder{}
+	title := ""
+	skip := ""
+	inTitle := false
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return strings.TrimSpace(title), text.String()
+		case xhtml.StartTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style":
+				skip = string(name)
+			case "title":
+				inTitle = true
+			}
+		case xhtml.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == skip {
+				skip = ""
+			}
+			if string(name) == "title" {
+				inTitle = false
+			}
+			text.WriteString(" ")
+		case xhtml.TextToken:
+			if skip != "" {
+				continue
+			}
+			if inTitle {
+				title += string(tokenizer.Text())
+				continue
+			}
+			text.Write(tokenizer.Text())
+		}
+	}
+}
+
+// searchIndex returns the search index of the workspace, the index is loaded
+// from the state directory, if persisted, and is reconciled with the files in
+// the background, the index is ready once loaded or reconciled
+func (w *Workspace) searchIndex() *search {
+	s := &w.search
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.built {
+		return s
+	}
+
+	s.docs = map[string]searchDoc{}
+	if data, err := os.ReadFile(w.statePath(searchDir, searchIndexFile)); err == nil {
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s.docs); err != nil {
+			log.Warn().Err(err).Msgf("Search index invalid: %s", w.Slug)
+			s.docs = map[string]searchDoc{}
+		} else {
+			s.ready = true
+		}
+	}
+	s.postings = map[string]map[string]int{}
+	s.totalLength = 0
+	for docPath, doc := range s.docs {
+		s.add(docPath, doc)
+	}
+	s.pending = map[string]bool{}
+	s.changed = make(chan struct{}, 1)
+	s.built = true
+	s.stale = true
+	s.changed <- struct{}{}
+
+	w.events.watchOnce.Do(func() {
+		go w.watch()
+	})
+	go w.indexDocs()
+	return s
+}
+
+func (s *search) add(docPath string, doc searchDoc) {
+	s.docs[docPath] = doc
+	s.totalLength += doc.Length
+	for term, freq := range doc.Terms {
+		if s.postings[term] == nil {
+			s.postings[term] = map[string]int{}
+		}
+		s.postings[term][docPath] = freq
+	}
+}
+
+func (s *search) remove(docPath string) {
+	doc, ok := s.docs[docPath]
+	if !ok {
+		return
+	}
+	delete(s.docs, docPath)
+	s.totalLength -= doc.Length
+	for term := range doc.Terms {
+		delete(s.postings[term], docPath)
+		if len(s.postings[term]) == 0 {
+			delete(s.postings, term)
+		}
+	}
+}
+
+// indexChanged marks the changed document to be indexed, the index is
+// reconciled with the files if too many documents are pending
+func (w *Workspace) indexChanged(docPath string) {
+	s := &w.search
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.built {
+		return
+	}
+	if len(s.pending) >= maxSearchPending {
+		s.pending = map[string]bool{}
+		s.stale = true
+	} else if !s.stale {
+		s.pending[docPath] = true
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// indexDocs indexes the changed documents until the workspace is cancelled,
+// the index is persisted when the workspace is cancelled
+func (w *Workspace) indexDocs() {
+	for {
+		select {
+		case <-w.ctx.Done():
+			// The index is not saved again once saved for the cancellation
+			s := &w.search
+			s.mtx.Lock()
+			if s.saveTimer != nil {
+				s.saveTimer.Stop()
+				s.saveTimer = nil
+			}
+			s.mtx.Unlock()
+			w.saveIndex()
+			return
+		case <-w.search.changed:
+			w.flushIndex()
+		}
+	}
+}
+
+// flushIndex indexes the pending documents, the index is reconciled with the files if stale
+func (w *Workspace) flushIndex() {
+	s := &w.search
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	s.mtx.Lock()
+	stale, pending := s.stale, s.pending
+	s.stale, s.pending = false, map[string]bool{}
+	s.reconciling = stale
+	s.mtx.Unlock()
+
+	if stale {
+		w.reconcileIndex()
+		s.mtx.Lock()
+		s.reconciling = false
+		s.ready = true
+		s.mtx.Unlock()
+		return
+	}
+	for docPath := range pending {
+		w.indexDoc(docPath)
+	}
+}
+
+// flushPending indexes the pending documents, unless the index is being
+// reconciled with the files, so that a search does not wait for the walk
+// of the files, returns false if the index is not ready
+func (w *Workspace) flushPending() bool {
+	s := &w.search
+	s.mtx.RLock()
+	ready, reconciling := s.ready, s.stale || s.reconciling
+	s.mtx.RUnlock()
+	if !ready {
+		return false
+	}
+	if !reconciling {
+		w.flushIndex()
+	}
+	return true
+}
+
+// reconcileIndex indexes the documents that have changed since they were
+// indexed and removes the documents that no longer exist, the update mutex is locked
+func (w *Workspace) reconcileIndex() {
+	found := map[string]bool{}
+	filepath.WalkDir(w.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != w.Root && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(w.Root, path)
+		if err != nil {
+			return nil
+		}
+		docPath := urlpath.Join("/", filepath.ToSlash(rel))
+		found[docPath] = true
+		w.indexDoc(docPath)
+		return nil
+	})
+
+	s := &w.search
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for docPath := range s.docs {
+		if !found[docPath] {
+			s.remove(docPath)
+		}
+	}
+	w.scheduleSaveIndex()
+}
+
+// indexDoc indexes the document, or removes it from the index if it no
+// longer exists, the document is not read again if its ETag is unchanged,
+// the update mutex is locked
+func (w *Workspace) indexDoc(docPath string) {
+	s := &w.search
+	filePath := filepath.Join(w.Root, filepath.FromSlash(docPath))
+	info, err := os.Stat(filePath)
+	if err != nil || info.IsDir() || !searchable(docPath) || info.Size() > maxSearchDocSize {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if _, ok := s.docs[docPath]; ok {
+			s.remove(docPath)
+			w.scheduleSaveIndex()
+		}
+		// The documents of a removed directory are removed
+		if err != nil {
+			for indexed := range s.docs {
+				if strings.HasPrefix(indexed, docPath+"/") {
+					s.remove(indexed)
+				}
+			}
+		}
+		return
+	}
+
+	etag := docETag(info)
+	s.mtx.RLock()
+	indexed, ok := s.docs[docPath]
+	s.mtx.RUnlock()
+	if ok && indexed.ETag == etag {
+		return
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+	title, text := extractText(docPath, data)
+	doc := searchDoc{ETag: etag, Title: title, Terms: map[string]int{}}
+	for _, token := range tokenize(text + "\n" + title) {
+		doc.Terms[token.term]++
+		doc.Length++
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.remove(docPath)
+	s.add(docPath, doc)
+	w.scheduleSaveIndex()
+}
+
+// scheduleSaveIndex persists the index after a delay, unless the workspace
+// is cancelled, the index mutex is locked
+func (w *Workspace) scheduleSaveIndex() {
+	s := &w.search
+	if s.saveTimer == nil && w.ctx.Err() == nil {
+		s.saveTimer = time.AfterFunc(searchSaveDelay, w.saveIndex)
+	}
+}
+
+// saveIndex persists the index in the state directory of the workspace
+func (w *Workspace) saveIndex() {
+	s := &w.search
+	s.save.Lock()
+	defer s.save.Unlock()
+
+	s.mtx.Lock()
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+	}
+	data := &bytes.Buffer{}
+	err := gob.NewEncoder(data).Encode(s.docs)
+	s.mtx.Unlock()
+	if err != nil {
+		log.Warn().Err(err).Msgf("Search index save failed: %s", w.Slug)
+		return
+	}
+
+	indexFilePath := w.statePath(searchDir, searchIndexFile)
+	err = os.MkdirAll(filepath.Dir(indexFilePath), os.ModePerm)
+	if err == nil {
+		err = os.WriteFile(indexFilePath+".tmp", data.Bytes(), 0666)
+	}
+	if err == nil {
+		err = os.Rename(indexFilePath+".tmp", indexFilePath)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msgf("Search index save failed: %s", w.Slug)
+	}
+}
+
+// query returns the documents with all the terms of the query ranked by BM25
+func (s *search) query(terms []string) []SearchResult {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if len(terms) == 0 || len(s.docs) == 0 {
+		return nil
+	}
+	var candidates map[string]int
+	for _, term := range terms {
+		postings := s.postings[term]
+		if len(postings) == 0 {
+			return nil
+		}
+		if candidates == nil || len(postings) < len(candidates) {
+			candidates = postings
+		}
+	}
+
+	n := float64(len(s.docs))
+	avgLength := float64(s.totalLength) / n
+	results := []SearchResult{}
+	for docPath := range candidates {
+		doc := s.docs[docPath]
+		score := 0.0
+		for _, term := range terms {
+			freq, ok := s.postings[term][docPath]
+			if !ok {
+				score = -1
+				break
+			}
+			df := float64(len(s.postings[term]))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			tf := float64(freq)
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.Length)/avgLength))
+		}
+		if score >= 0 {
+			results = append(results, SearchResult{Path: docPath, Title: doc.Title, Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	return results
+}
+
+// snippet returns the HTML excerpt of the text around the first term of the
+// query, the terms of the query are highlighted
+func snippet(text string, terms []string) string {
+	match := map[string]bool{}
+	for _, term := range terms {
+		match[term] = true
+	}
+	tokens := tokenize(text)
+	first := -1
+	for idx, token := range tokens {
+		if match[token.term] {
+			first = idx
+			break
+		}
+	}
+
+	start, end := 0, len(text)
+	if first >= 0 {
+		start = tokens[first].start - searchSnippetSize/3
+	}
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	if start+searchSnippetSize < end {
+		end = start + searchSnippetSize
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+
+	out := &strings.Builder{}
+	if start > 0 {
+		out.WriteString("…")
+	}
+	offset := start
+	for _, token := range tokens {
+		if token.start < start || token.end > end || !match[token.term] {
+			continue
+		}
+		out.WriteString(html.EscapeString(text[offset:token.start]))
+		out.WriteString("<mark>")
+		out.WriteString(html.EscapeString(text[token.start:token.end]))
+		out.WriteString("</mark>")
+		offset = token.end
+	}
+	out.WriteString(html.EscapeString(text[offset:end]))
+	if end < len(text) {
+		out.WriteString("…")
+	}
+	return strings.Join(strings.Fields(out.String()), " ")
+}
+
+// serveSearch serves the documents matching the 'q' query, ranked and
+// highlighted, the results are paginated by the 'limit' and 'offset' queries
+func (w *Workspace) serveSearch(res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	switch req.Method {
+	case "GET", "HEAD":
+	case "OPTIONS":
+		res.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		res.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		res.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.serveError(http.StatusMethodNotAllowed, res, req)
+		return
+	}
+
+	query := req.URL.Query()
+	limit, offset := defaultSearchLimit, 0
+	var err error
+	if value := query.Get(LimitQuery); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			w.serveError(http.StatusBadRequest, res, req)
+			return
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			w.serveError(http.StatusBadRequest, res, req)
+			return
+		}
+	}
+
+	terms := []string{}
+	for _, token := range tokenize(query.Get("q")) {
+		terms = append(terms, token.term)
+	}
+
+	s := w.searchIndex()
+	// The changed documents are indexed before the search, the
+	// search is unavailable until the index is first built
+	if !w.flushPending() {
+		res.Header().Set("Retry-After", "1")
+		w.serveError(http.StatusServiceUnavailable, res, req)
+		return
+	}
+
+	principal := auth.Ctx(req.Context())
+	matches := []SearchResult{}
+	for _, result := range s.query(terms) {
+		allowed, err := w.authorize(principal, "GET", result.Path)
+		if err != nil {
+			log.Warn().Err(err).Msg("Access rules evaluation failed")
+			w.serveError(err, res, req)
+			return
+		}
+		if allowed {
+			matches = append(matches, result)
+		}
+	}
+
+	results := SearchResults{Total: len(matches), Results: []SearchResult{}}
+	if offset < len(matches) {
+		end := offset + limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		results.Results = matches[offset:end]
+	}
+	for idx := range results.Results {
+		result := &results.Results[idx]
+		data, err := os.ReadFile(filepath.Join(w.Root, filepath.FromSlash(result.Path)))
+		if err != nil {
+			continue
+		}
+		_, text := extractText(result.Path, data)
+		result.Snippet = snippet(text, terms)
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		res.Write(data)
+	}
+}
@@ -0,0 +1,162 @@
+package workspace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	terms := []string{}
+	for _, token := range tokenize("Hello, World! a été 42x") {
+		terms = append(terms, token.term)
+	}
+	require.Equal(t, []string{"hello", "world", "été", "42x"}, terms)
+}
+
+func TestSnippet(t *testing.T) {
+	require.Equal(t, "a <mark>Cat</mark> &amp; a dog", snippet("a Cat & a dog", []string{"cat"}))
+	long := strings.Repeat("word ", 100) + "needle " + strings.Repeat("word ", 100)
+	s := snippet(long, []string{"needle"})
+	require.True(t, strings.HasPrefix(s, "…"), s)
+	require.True(t, strings.HasSuffix(s, "…"), s)
+	require.Contains(t, s, "<mark>needle</mark>")
+}
+
+func TestSearch(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"notes/a.txt":      "The quick brown fox jumps over the lazy dog",
+		"notes/b.md":       "---\ntitle: Foxes\n---\n# Heading\n\nA fox, a fox and another fox.",
+		"pages/c.html":     "<html><head><title>Page</title><style>.fox{}</style></head><body><p>Brown <b>bear</b></p><script>fox()</script></body></html>",
+		"data/d.json":      `{"name":"brown owl","count":3,"fox":1}`,
+		"data/e.bin":       "fox",
+		"_partials/f.txt":  "fox",
+		".makeshiftd/g.md": "fox",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		require.Nil(t, err, err)
+		err = os.WriteFile(path, []byte(content), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+
+	w := New(&testMakeshiftd{}, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+
+	search := func(t *testing.T, w *Workspace, query string) (int, SearchResults) {
+		req := httptest.NewRequest("GET", "/"+SearchAPI+query, nil)
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		results := SearchResults{}
+		if res.Code == http.StatusOK {
+			require.Equal(t, "application/json", res.Header().Get("Content-Type"))
+			err := json.Unmarshal(res.Body.Bytes(), &results)
+			require.Nil(t, err, err)
+		}
+		return res.Code, results
+	}
+	paths := func(results SearchResults) string {
+		paths := []string{}
+		for _, result := range results.Results {
+			paths = append(paths, result.Path)
+		}
+		return strings.Join(paths, ",")
+	}
+
+	t.Run("Building", func(t *testing.T) {
+		// The search is unavailable until the index is built in the background
+		w.search.update.Lock()
+		req := httptest.NewRequest("GET", "/"+SearchAPI+"?q=fox", nil)
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		w.search.update.Unlock()
+		require.Equal(t, http.StatusServiceUnavailable, res.Code)
+		require.Equal(t, "1", res.Header().Get("Retry-After"))
+		require.Eventually(t, func() bool {
+			code, _ := search(t, w, "?q=fox")
+			return code == http.StatusOK
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	table := []struct {
+		Query string
+		Code  int
+		Total int
+		Paths string
+	}{
+		{Query: "?q=fox", Code: http.StatusOK, Total: 2, Paths: "/notes/b.md,/notes/a.txt"},
+		{Query: "?q=BROWN", Code: http.StatusOK, Total: 3, Paths: "/data/d.json,/pages/c.html,/notes/a.txt"},
+		{Query: "?q=brown+fox", Code: http.StatusOK, Total: 1, Paths: "/notes/a.txt"},
+		{Query: "?q=foxes", Code: http.StatusOK, Total: 1, Paths: "/notes/b.md"},
+		{Query: "?q=brown&limit=1&offset=1", Code: http.StatusOK, Total: 3, Paths: "/pages/c.html"},
+		{Query: "?q=brown&offset=5", Code: http.StatusOK, Total: 3, Paths: ""},
+		{Query: "?q=missing", Code: http.StatusOK, Total: 0, Paths: ""},
+		{Query: "", Code: http.StatusOK, Total: 0, Paths: ""},
+		{Query: "?q=fox&limit=0", Code: http.StatusBadRequest},
+		{Query: "?q=fox&offset=-1", Code: http.StatusBadRequest},
+	}
+
+	for _, row := range table {
+		t.Run(row.Query, func(t *testing.T) {
+			code, results := search(t, w, row.Query)
+			require.Equal(t, row.Code, code)
+			if code == http.StatusOK {
+				require.Equal(t, row.Total, results.Total)
+				require.Equal(t, row.Paths, paths(results))
+			}
+		})
+	}
+
+	t.Run("Highlight", func(t *testing.T) {
+		_, results := search(t, w, "?q=bear")
+		require.Len(t, results.Results, 1)
+		require.Equal(t, "Page", results.Results[0].Title)
+		require.Equal(t, "Brown <mark>bear</mark>", results.Results[0].Snippet)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/notes/a.txt", strings.NewReader("A slow green turtle"))
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		_, results := search(t, w, "?q=turtle")
+		require.Equal(t, "/notes/a.txt", paths(results))
+
+		// The changes of the files are indexed from the file system watcher,
+		// the file is written until the watcher is started
+		require.Eventually(t, func() bool {
+			err := os.WriteFile(filepath.Join(root, "notes", "w.txt"), []byte("watched"), os.ModePerm)
+			require.Nil(t, err, err)
+			_, results := search(t, w, "?q=watched")
+			return results.Total == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		err := os.Remove(filepath.Join(root, "notes", "b.md"))
+		require.Nil(t, err, err)
+		require.Eventually(t, func() bool {
+			_, results := search(t, w, "?q=fox")
+			return results.Total == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Persist", func(t *testing.T) {
+		w.saveIndex()
+		_, err := os.Stat(w.statePath(searchDir, searchIndexFile))
+		require.Nil(t, err, err)
+		require.Equal(t, int64(len(files)), w.Usage().Files)
+
+		other := New(&testMakeshiftd{}, "other", root, nil)
+		require.Nil(t, other.err, other.err)
+		_, results := search(t, other, "?q=turtle")
+		require.Equal(t, "/notes/a.txt", paths(results))
+	})
+}
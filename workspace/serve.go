@@ -1,53 +1,98 @@
 package workspace
 
 import (
+	"bytes"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/metrics"
 	"github.com/makeshiftd/makeshiftd/urlpath"
 )
 
 func (w *Workspace) serveDoc(docPath string, res http.ResponseWriter, req *http.Request) {
 
+	// The documents locked with WebDAV are written only with the lock token
+	if w.locks != nil && (req.Method == "PUT" || req.Method == "PATCH") {
+		release, code := w.confirmLocks(docPath, req)
+		if code != 0 {
+			w.serveError(code, res, req)
+			return
+		}
+		defer release()
+	}
+
+	if uploadQuery(req) {
+		w.serveUpload(docPath, res, req)
+		return
+	}
+
+	// The fragments of JSON documents are selected by a JSON Pointer or a JSONPath,
+	// only the JSON documents are patched
+	if jsonQuery(req) || (req.Method == "PATCH" && strings.EqualFold(filepath.Ext(docPath), ".json")) {
+		w.serveDocJSON(docPath, res, req)
+		return
+	}
+
 	switch req.Method {
-	case "GET":
+	case "GET", "HEAD":
 		w.serveDocGet(docPath, res, req)
 	case "POST":
 		w.serveDocPost(docPath, res, req)
 	case "PUT":
 		w.serveDocPut(docPath, res, req)
-	case "PATCH":
-		// serveDocPatch(docPath, res, req)
+	case "COPY", "MOVE":
+		w.serveDocCopy(docPath, req.Method == "MOVE", res, req)
 	default:
-		w.serveError(http.StatusMethodNotAllowed, res, req)
+		w.serveMethodNotAllowed(docPath, false, res, req)
 	}
 }
 
-func (w *Workspace) serveDocGet(docPath string, res http.ResponseWriter, req *http.Request) {
-
+// resolveDocFile returns the file path and info of the document, the index
+// document is resolved if the document is a directory, and the template of
+// the document is resolved if the document does not exist
+func (w *Workspace) resolveDocFile(docPath string) (string, os.FileInfo, error) {
 	docFilePath := filepath.FromSlash(docPath)
 	docFilePath = filepath.Join(w.Root, docFilePath)
 
 	docFileInfo, err := os.Stat(docFilePath)
+	if err != nil && os.IsNotExist(err) && docPath != "" {
+		tmplFileInfo, tmplErr := os.Stat(docFilePath + TemplateExt)
+		if tmplErr == nil && !tmplFileInfo.IsDir() {
+			return docFilePath + TemplateExt, tmplFileInfo, nil
+		}
+	}
 	if err == nil && docFileInfo.IsDir() {
 		pattern := strings.ReplaceAll(docFilePath, "*", "\\*")
 		pattern = strings.ReplaceAll(pattern, "?", "\\?")
 		pattern = strings.ReplaceAll(pattern, "[", "\\[")
 		pattern = strings.ReplaceAll(pattern, "\\", "\\\\") // Windows?
 		pattern = filepath.Join(docFilePath, "index.*")
-		matches, err := filepath.Glob(pattern)
-		if err == nil && len(matches) > 0 {
+		matches, globErr := filepath.Glob(pattern)
+		if globErr == nil && len(matches) > 0 {
 			// TODO: content nego
 			sort.Strings(matches)
 			docFilePath = matches[0]
 			docFileInfo, err = os.Stat(docFilePath)
 		}
 	}
+	return docFilePath, docFileInfo, err
+}
+
+func (w *Workspace) serveDocGet(docPath string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	docFilePath, docFileInfo, err := w.resolveDocFile(docPath)
 	log.Debug().Msgf("Get file path: %s", docFilePath)
+	if err == nil && docFileInfo.IsDir() && w.collections != nil {
+		w.serveCollection(docPath, res, req)
+		return
+	}
 	if (err == nil && docFileInfo.IsDir()) ||
 		(err != nil && os.IsNotExist(err)) {
 		w.serveError(http.StatusNotFound, res, req)
@@ -58,6 +103,20 @@ func (w *Workspace) serveDocGet(docPath string, res http.ResponseWriter, req *ht
 		return
 	}
 
+	if strings.HasSuffix(docFilePath, TemplateExt) {
+		w.serveTemplate(docPath, docFilePath, docFileInfo, res, req)
+		return
+	}
+
+	// The Markdown documents are rendered unless the source is requested
+	if isMarkdown(docFilePath) {
+		res.Header().Add("Vary", "Accept")
+		if _, raw := req.URL.Query()[RawQuery]; !raw && acceptsHTML(req) {
+			w.serveMarkdown(docPath, docFilePath, res, req)
+			return
+		}
+	}
+
 	docFile, err := os.Open(docFilePath)
 	if err != nil {
 		w.serveError(err, res, req)
@@ -68,11 +127,52 @@ func (w *Workspace) serveDocGet(docPath string, res http.ResponseWriter, req *ht
 	if filepath.Base(docPath) == "index.html" {
 		docFilePath = "noredirect.html"
 	}
+	etag := docETag(docFileInfo)
+
+	// The live reload script is injected into the HTML documents in development mode
+	if w.liveReload && isHTML(mime.TypeByExtension(filepath.Ext(docFilePath))) {
+		doc, err := io.ReadAll(docFile)
+		if err != nil {
+			w.serveError(err, res, req)
+			return
+		}
+		res.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+`-livereload"`)
+		res.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(res, req, docFilePath, docFileInfo.ModTime(), bytes.NewReader(w.injectLiveReload(doc)))
+		return
+	}
+
+	res.Header().Set("ETag", etag)
 	http.ServeContent(res, req, docFilePath, docFileInfo.ModTime(), docFile)
 }
 
 func (w *Workspace) serveDocPost(docPath string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
 	docDir, docName := urlpath.Split(docPath)
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	form := mediaType == "multipart/form-data" || mediaType == "application/x-www-form-urlencoded"
+
+	// A document with a generated name is created in an existing directory,
+	// the name has the JSON extension if the body is a form or JSON
+	if info, err := os.Stat(filepath.Join(w.Root, filepath.FromSlash(docPath))); err == nil && info.IsDir() {
+		docDir, docName = docPath, "*"
+		if form || mediaType == "application/json" {
+			docName += ".json"
+		}
+		principal := auth.Ctx(req.Context())
+		allowed, err := w.authorize(principal, "POST", urlpath.Join(docDir, docName))
+		if err != nil {
+			log.Warn().Err(err).Msg("Access rules evaluation failed")
+			w.serveError(err, res, req)
+			return
+		}
+		if !allowed {
+			log.Debug().Msgf("Access denied: %s POST %s", principal.Name, urlpath.Join(docDir, docName))
+			w.serveError(http.StatusForbidden, res, req)
+			return
+		}
+	}
 
 	docFileDir := filepath.FromSlash(docDir)
 	docFileDir = filepath.Join(w.Root, docFileDir)
@@ -92,41 +192,78 @@ func (w *Workspace) serveDocPost(docPath string, res http.ResponseWriter, req *h
 		}
 	}
 
-	err := os.MkdirAll(docFileDir, os.ModePerm)
+	if form {
+		w.serveDocPostForm(docDir, docName, res, req)
+		return
+	}
+
+	docName, nbytes, err := w.createDocFile(docDir, docName, req.Body, req.ContentLength)
 	if err != nil {
-		w.serveError(err, res, req)
+		log.Debug().Err(err).Msgf("Post file failed: %s", docFilePath)
+		w.serveWriteError(err, res, req)
 		return
 	}
+	log.Trace().Msgf("Request body copied to file: %d bytes", nbytes)
+	w.record(req.Context(), "POST", urlpath.Join(docDir, docName))
+
+	req.Body.Close()
+
+	location := urlpath.Join("/", w.Slug, docDir, docName)
+	res.Header().Add("Location", location)
+	res.WriteHeader(http.StatusCreated)
+}
+
+// createDocFile creates a new document in the directory from the body, a unique
+// name is generated if the name contains '*', returns the name of the document
+// and the number of bytes written
+func (w *Workspace) createDocFile(docDir, docName string, body io.Reader, size int64) (string, int64, error) {
+	reservation, err := w.reserveUsage(0, size, true)
+	if err != nil {
+		return "", 0, err
+	}
+	var nbytes, files int64
+	defer func() { reservation.release(nbytes, files) }()
+
+	body, err = w.validateBody(urlpath.Join(docDir, docName), reservation.limit(body))
+	if err != nil {
+		return "", 0, err
+	}
+
+	docFileDir := filepath.Join(w.Root, filepath.FromSlash(docDir))
+	err = os.MkdirAll(docFileDir, os.ModePerm)
+	if err != nil {
+		return "", 0, err
+	}
 
 	var docFile *os.File
-	if mktemp {
+	if strings.Contains(docName, "*") {
 		docFile, err = os.CreateTemp(docFileDir, docName)
 	} else {
-		docFile, err = os.Create(docFilePath)
+		docFile, err = os.OpenFile(filepath.Join(docFileDir, docName), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
 	}
 	if err != nil {
-		w.serveError(err, res, req)
-		return
+		return "", 0, err
 	}
-	defer docFile.Close()
 
-	nbytes, err := io.Copy(docFile, req.Body)
+	written, err := io.Copy(docFile, body)
+	if closeErr := docFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		log.Err(err).Msgf("Error copying request body to file")
-		w.serveError(err, res, req)
-		return
+		os.Remove(docFile.Name())
+		return "", 0, err
 	}
-	log.Trace().Msgf("Request body copied to file: %d bytes", nbytes)
-
-	req.Body.Close()
+	metrics.WrittenBytes.WithLabelValues(w.Slug).Add(float64(written))
+	nbytes, files = written, 1
 
 	docName = filepath.Base(docFile.Name())
-	location := urlpath.Join("/", w.Slug, docDir, docName)
-	res.Header().Add("Location", location)
-	res.WriteHeader(http.StatusCreated)
+	w.notify(EventCreate, urlpath.Join(docDir, docName))
+	return docName, nbytes, nil
 }
 
 func (w *Workspace) serveDocPut(docPath string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
 	docDir, docName := urlpath.Split(docPath)
 
 	docFileDir := filepath.FromSlash(docDir)
@@ -134,7 +271,10 @@ func (w *Workspace) serveDocPut(docPath string, res http.ResponseWriter, req *ht
 	docFilePath := filepath.Join(docFileDir, docName)
 	log.Debug().Msgf("Put file path: %s", docFilePath)
 
-	docFileExists := true
+	// The document is not modified in place concurrently
+	unlock := lockFile(docFilePath)
+	defer unlock()
+
 	docFileInfo, err := os.Stat(docFilePath)
 	if err != nil && !os.IsNotExist(err) {
 		w.serveError(err, res, req)
@@ -146,7 +286,28 @@ func (w *Workspace) serveDocPut(docPath string, res http.ResponseWriter, req *ht
 	}
 
 	if err != nil {
-		docFileExists = false
+		docFileInfo = nil
+	}
+
+	if req.Header.Get("Content-Range") != "" {
+		w.serveDocPutRange(docPath, docFileInfo, res, req)
+		return
+	}
+
+	var replacedBytes int64
+	if docFileInfo != nil {
+		replacedBytes = docFileInfo.Size()
+	}
+	reservation, err := w.reserveUsage(replacedBytes, req.ContentLength, docFileInfo == nil)
+	if err != nil {
+		log.Debug().Err(err).Msgf("Put file rejected: %s", docFilePath)
+		w.serveWriteError(err, res, req)
+		return
+	}
+	var usedBytes, usedFiles int64
+	defer func() { reservation.release(usedBytes, usedFiles) }()
+
+	if docFileInfo == nil {
 		err := os.MkdirAll(docFileDir, os.ModePerm)
 		if err != nil {
 			w.serveError(err, res, req)
@@ -154,26 +315,74 @@ func (w *Workspace) serveDocPut(docPath string, res http.ResponseWriter, req *ht
 		}
 	}
 
-	docFile, err := os.Create(docFilePath)
+	body, err := w.validateBody(docPath, reservation.limit(req.Body))
 	if err != nil {
-		w.serveError(err, res, req)
+		log.Debug().Err(err).Msgf("Put file rejected: %s", docFilePath)
+		w.serveWriteError(err, res, req)
 		return
 	}
-	defer docFile.Close()
 
-	nbytes, err := io.Copy(docFile, req.Body)
+	nbytes, err := writeDocFile(docFilePath, docFileInfo, body)
 	if err != nil {
 		log.Err(err).Msgf("Error copying request body to file")
-		w.serveError(err, res, req)
+		w.serveWriteError(err, res, req)
 		return
 	}
 	log.Trace().Msgf("Request body copied to file: %d bytes", nbytes)
+	metrics.WrittenBytes.WithLabelValues(w.Slug).Add(float64(nbytes))
 
 	req.Body.Close()
 
-	if docFileExists {
+	usedBytes = nbytes - replacedBytes
+	if docFileInfo == nil {
+		usedFiles = 1
+	}
+
+	w.record(req.Context(), "PUT", docPath)
+	if docFileInfo != nil {
+		w.notify(EventModify, docPath)
 		res.WriteHeader(http.StatusOK)
 	} else {
+		w.notify(EventCreate, docPath)
 		res.WriteHeader(http.StatusCreated)
 	}
 }
+
+// writeDocFile writes the body to the document file, an existing
+// document file is replaced only if the body is copied completely
+func writeDocFile(docFilePath string, docFileInfo os.FileInfo, body io.Reader) (int64, error) {
+	if docFileInfo == nil {
+		docFile, err := os.Create(docFilePath)
+		if err != nil {
+			return 0, err
+		}
+		nbytes, err := io.Copy(docFile, body)
+		if closeErr := docFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(docFilePath)
+		}
+		return nbytes, err
+	}
+
+	docFileDir, docName := filepath.Split(docFilePath)
+	tmpFile, err := os.CreateTemp(docFileDir, "."+docName+".*")
+	if err != nil {
+		return 0, err
+	}
+	nbytes, err := io.Copy(tmpFile, body)
+	if err == nil {
+		err = tmpFile.Chmod(docFileInfo.Mode().Perm())
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), docFilePath)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+	return nbytes, err
+}
@@ -0,0 +1,230 @@
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// TemplateExt is the extension of the template documents, the template
+// 'page.html.tmpl' is rendered when the document 'page.html' is requested
+const TemplateExt = ".tmpl"
+
+// PartialsDir is the directory of the workspace with the partial templates,
+// the partial '_partials/header.html.tmpl' is included as 'header.html'
+const PartialsDir = "_partials"
+
+// templateData is the data of the rendered template, the content and the
+// metadata of the front matter are set if a Markdown document is rendered
+type templateData struct {
+	Path    string
+	Query   url.Values
+	Meta    map[string]interface{}
+	Content template.HTML
+}
+
+// templates are the parsed templates of the workspace, a template
+// is parsed again if the template or a partial has changed
+type templates struct {
+	mtx    sync.Mutex
+	parsed map[string]parsedTemplate
+}
+
+type parsedTemplate struct {
+	stamp string
+	tmpl  *template.Template
+}
+
+// templateFuncs are the placeholders of the helpers of the templates,
+// the helpers are bound to the request when the template is rendered
+var templateFuncs = template.FuncMap{
+	"json":     func(string) (interface{}, error) { return nil, nil },
+	"query":    func(string) string { return "" },
+	"queryAll": func(string) []string { return nil },
+}
+
+// partialFiles returns the file paths of the partial templates by name
+func (w *Workspace) partialFiles() (map[string]string, error) {
+	dir := filepath.Join(w.Root, PartialsDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	partials := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), TemplateExt) {
+			continue
+		}
+		partials[strings.TrimSuffix(entry.Name(), TemplateExt)] = filepath.Join(dir, entry.Name())
+	}
+	return partials, nil
+}
+
+// parseTemplate returns the parsed template with the partials of the workspace,
+// the template is cached until the template or a partial has changed
+func (w *Workspace) parseTemplate(tmplFilePath string, tmplFileInfo os.FileInfo) (*template.Template, error) {
+	partials, err := w.partialFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	stamp := &strings.Builder{}
+	stamp.WriteString(docETag(tmplFileInfo))
+	infos := map[string]os.FileInfo{}
+	for name, path := range partials {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		infos[name] = info
+	}
+	names := make([]string, 0, len(infos))
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(stamp, ",%s=%s", name, docETag(infos[name]))
+	}
+
+	w.templates.mtx.Lock()
+	defer w.templates.mtx.Unlock()
+
+	if parsed, ok := w.templates.parsed[tmplFilePath]; ok && parsed.stamp == stamp.String() {
+		return parsed.tmpl, nil
+	}
+
+	tmpl := template.New(filepath.Base(tmplFilePath)).Funcs(templateFuncs)
+	for _, name := range names {
+		content, err := os.ReadFile(partials[name])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.New(name).Parse(string(content)); err != nil {
+			return nil, err
+		}
+	}
+	content, err := os.ReadFile(tmplFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmpl.Parse(string(content)); err != nil {
+		return nil, err
+	}
+
+	if w.templates.parsed == nil {
+		w.templates.parsed = map[string]parsedTemplate{}
+	}
+	w.templates.parsed[tmplFilePath] = parsedTemplate{stamp: stamp.String(), tmpl: tmpl}
+	return tmpl, nil
+}
+
+// renderTemplate renders the template with the data for the request, the paths
+// of the JSON documents are relative to the document directory
+func (w *Workspace) renderTemplate(docDir, tmplFilePath string, tmplFileInfo os.FileInfo, data templateData, req *http.Request) ([]byte, error) {
+	parsed, err := w.parseTemplate(tmplFilePath, tmplFileInfo)
+	if err != nil {
+		return nil, err
+	}
+	// The cached template is never executed so that it can be cloned
+	tmpl, err := parsed.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	principal := auth.Ctx(req.Context())
+	query := req.URL.Query()
+
+	tmpl.Funcs(template.FuncMap{
+		// json loads the JSON document, the path is relative to the directory of the template
+		"json": func(path string) (interface{}, error) {
+			if !strings.HasPrefix(path, "/") {
+				path = urlpath.Join(docDir, path)
+			}
+			segments, exec, ok := docSegments(path)
+			if !ok || exec || len(segments) == 0 {
+				return nil, fmt.Errorf("JSON document path invalid: %s", path)
+			}
+			dataPath := urlpath.Join(segments...)
+			allowed, err := w.authorize(principal, "GET", dataPath)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				return nil, fmt.Errorf("JSON document access denied: %s", dataPath)
+			}
+			content, err := os.ReadFile(filepath.Join(w.Root, filepath.FromSlash(dataPath)))
+			if err != nil {
+				return nil, err
+			}
+			var data interface{}
+			if err := json.Unmarshal(content, &data); err != nil {
+				return nil, fmt.Errorf("JSON document invalid: %s: %w", dataPath, err)
+			}
+			return data, nil
+		},
+		"query": func(name string) string {
+			return query.Get(name)
+		},
+		"queryAll": func(name string) []string {
+			return query[name]
+		},
+	})
+
+	out := &bytes.Buffer{}
+	err = tmpl.Execute(out, data)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// serveTemplate serves the document rendered from the template, the ETag is
+// the digest of the output so that unchanged output is not sent again
+func (w *Workspace) serveTemplate(docPath, tmplFilePath string, tmplFileInfo os.FileInfo, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	docDir, err := filepath.Rel(w.Root, filepath.Dir(tmplFilePath))
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	data := templateData{Path: urlpath.Join("/", docPath), Query: req.URL.Query()}
+	out, err := w.renderTemplate(filepath.ToSlash(docDir), tmplFilePath, tmplFileInfo, data, req)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Template rendering failed: %s", tmplFilePath)
+		w.serveError(err, res, req)
+		return
+	}
+
+	docFilePath := strings.TrimSuffix(tmplFilePath, TemplateExt)
+	contentType := mime.TypeByExtension(filepath.Ext(docFilePath))
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	if w.liveReload && isHTML(contentType) {
+		out = w.injectLiveReload(out)
+	}
+
+	res.Header().Set("Content-Type", contentType)
+	res.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(out)))
+	res.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(res, req, docFilePath, time.Time{}, bytes.NewReader(out))
+}
@@ -0,0 +1,99 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"_partials/title.html.tmpl": `<h1>{{.}}</h1>`,
+		"data/items.json":           `{"items":["a","b"]}`,
+		"secret.json":               `{"secret":true}`,
+		"list/index.html.tmpl":      `{{template "title.html" "List"}}{{range (json "../data/items.json").items}}<li>{{.}}</li>{{end}}`,
+		"search.html.tmpl":          `{{.Path}}:{{query "q"}}:{{len (queryAll "q")}}`,
+		"secret.html.tmpl":          `{{(json "secret.json").secret}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		require.Nil(t, err, err)
+		err = os.WriteFile(path, []byte(content), os.ModePerm)
+		require.Nil(t, err, err)
+	}
+
+	config := viper.New()
+	config.Set("access", map[string]interface{}{
+		"rules": []interface{}{
+			map[string]interface{}{
+				"paths":      []string{"secret.json"},
+				"methods":    []string{"GET"},
+				"principals": []string{"*"},
+				"effect":     "deny",
+			},
+		},
+	})
+
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	table := []struct {
+		Path        string
+		Code        int
+		Body        string
+		ContentType string
+	}{
+		{Path: "/list/", Code: http.StatusOK, Body: "<h1>List</h1><li>a</li><li>b</li>", ContentType: "text/html; charset=utf-8"},
+		{Path: "/search.html?q=<b>&q=c", Code: http.StatusOK, Body: "/search.html:&lt;b&gt;:2", ContentType: "text/html; charset=utf-8"},
+		{Path: "/secret.html", Code: http.StatusInternalServerError},
+		{Path: "/missing.html", Code: http.StatusNotFound},
+	}
+
+	for _, row := range table {
+		t.Run(row.Path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", row.Path, nil)
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code)
+			if row.Code == http.StatusOK {
+				require.Equal(t, row.Body, res.Body.String())
+				require.Equal(t, row.ContentType, res.Header().Get("Content-Type"))
+			}
+		})
+	}
+
+	t.Run("Cache", func(t *testing.T) {
+		get := func(etag string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", "/list/", nil)
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			return res
+		}
+
+		res := get("")
+		require.Equal(t, http.StatusOK, res.Code)
+		etag := res.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		res = get(etag)
+		require.Equal(t, http.StatusNotModified, res.Code)
+
+		// The partial is parsed again when changed
+		err := os.WriteFile(filepath.Join(root, PartialsDir, "title.html.tmpl"), []byte(`<h2>{{.}}</h2><hr>`), os.ModePerm)
+		require.Nil(t, err, err)
+		res = get(etag)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, "<h2>List</h2><hr><li>a</li><li>b</li>", res.Body.String())
+	})
+}
@@ -0,0 +1,417 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makeshiftd/makeshiftd/metrics"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// UploadQuery is the query parameter to get the status of, or to
+// cancel, a resumable upload of a document
+const UploadQuery = "upload"
+
+// uploadQuery returns true if the request is for the resumable upload of the document
+func uploadQuery(req *http.Request) bool {
+	_, ok := req.URL.Query()[UploadQuery]
+	return ok
+}
+
+// UploadOffsetHeader is the response header with the number of bytes
+// of a resumable upload that have been received
+const UploadOffsetHeader = "Upload-Offset"
+
+// uploadsDir is the directory of the workspace state in which
+// the partial uploads are staged
+const uploadsDir = "uploads"
+
+// contentRange is a parsed Content-Range header, the total is -1 if unknown
+type contentRange struct {
+	Start int64
+	End   int64
+	Total int64
+}
+
+// parseContentRange parses a Content-Range header of the form
+// 'bytes start-end/total' where the total may be '*' if unknown
+func parseContentRange(value string) (contentRange, error) {
+	r := contentRange{}
+	invalid := fmt.Errorf("Content-Range invalid: %s", value)
+
+	if !strings.HasPrefix(value, "bytes ") {
+		return r, invalid
+	}
+	value = strings.TrimSpace(strings.TrimPrefix(value, "bytes "))
+	idx := strings.Index(value, "/")
+	if idx < 0 {
+		return r, invalid
+	}
+	span, total := value[:idx], value[idx+1:]
+
+	r.Total = -1
+	if total != "*" {
+		t, err := strconv.ParseInt(total, 10, 64)
+		if err != nil || t < 0 {
+			return r, invalid
+		}
+		r.Total = t
+	}
+
+	idx = strings.Index(span, "-")
+	if idx < 0 {
+		return r, invalid
+	}
+	start, err := strconv.ParseInt(span[:idx], 10, 64)
+	if err != nil || start < 0 {
+		return r, invalid
+	}
+	end, err := strconv.ParseInt(span[idx+1:], 10, 64)
+	if err != nil || end < start {
+		return r, invalid
+	}
+	if r.Total >= 0 && end >= r.Total {
+		return r, invalid
+	}
+	r.Start = start
+	r.End = end
+	return r, nil
+}
+
+// defaultUploadExpiry is the time after which a staged upload
+// that has not been written is removed, unless configured
+const defaultUploadExpiry = 24 * time.Hour
+
+// uploads are the staged uploads of the workspace, the expired
+// uploads are removed once the first upload is staged
+type uploads struct {
+	expiry     time.Duration
+	expireOnce sync.Once
+}
+
+// fileLock is the lock of a file path and the number of its
+// holders, including those waiting, the lock is removed with
+// the last holder so that the locks do not accumulate
+type fileLock struct {
+	mtx  sync.Mutex
+	refs int
+}
+
+// fileLocks serializes the writes to the staged uploads, and
+// to the documents, by the file path
+var fileLocks = struct {
+	mtx   sync.Mutex
+	locks map[string]*fileLock
+}{locks: map[string]*fileLock{}}
+
+func lockFile(filePath string) func() {
+	fileLocks.mtx.Lock()
+	l := fileLocks.locks[filePath]
+	if l == nil {
+		l = &fileLock{}
+		fileLocks.locks[filePath] = l
+	}
+	l.refs++
+	fileLocks.mtx.Unlock()
+
+	l.mtx.Lock()
+	return func() {
+		l.mtx.Unlock()
+		fileLocks.mtx.Lock()
+		defer fileLocks.mtx.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(fileLocks.locks, filePath)
+		}
+	}
+}
+
+// lockFiles locks the file paths in order so that
+// concurrent writes of the same files cannot deadlock
+func lockFiles(filePaths ...string) func() {
+	sort.Strings(filePaths)
+	unlocks := []func(){}
+	for idx, filePath := range filePaths {
+		if idx > 0 && filePath == filePaths[idx-1] {
+			continue
+		}
+		unlocks = append(unlocks, lockFile(filePath))
+	}
+	return func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+}
+
+// stagedFilePath returns the path of the file in which the upload of the document is staged
+func (w *Workspace) stagedFilePath(docPath string) string {
+	sum := sha256.Sum256([]byte(strings.Trim(docPath, "/")))
+	return w.statePath(uploadsDir, hex.EncodeToString(sum[:])+".part")
+}
+
+// stagedOffset returns the number of bytes of the staged upload of the document
+func (w *Workspace) stagedOffset(docPath string) (int64, error) {
+	info, err := os.Stat(w.stagedFilePath(docPath))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// serveDocPutRange writes a byte range of the document to the staged upload,
+// the staged upload replaces the document once the last byte is received
+func (w *Workspace) serveDocPutRange(docPath string, docFileInfo os.FileInfo, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	r, err := parseContentRange(req.Header.Get("Content-Range"))
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		res.Write([]byte(err.Error()))
+		return
+	}
+	length := r.End - r.Start + 1
+	if req.ContentLength >= 0 && req.ContentLength != length {
+		res.WriteHeader(http.StatusBadRequest)
+		res.Write([]byte("Content-Length does not match Content-Range"))
+		return
+	}
+
+	w.uploads.expireOnce.Do(func() {
+		go w.expireUploads()
+	})
+
+	stagedFilePath := w.stagedFilePath(docPath)
+	unlock := lockFile(stagedFilePath)
+	defer unlock()
+
+	offset, err := w.stagedOffset(docPath)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	res.Header().Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+	if r.Start > offset {
+		log.Debug().Msgf("Upload range not contiguous: %s: %d > %d", docPath, r.Start, offset)
+		w.serveError(http.StatusConflict, res, req)
+		return
+	}
+
+	growth := r.End + 1 - offset
+	if growth < 0 {
+		growth = 0
+	}
+	reservation, err := w.reserveUsage(0, growth, offset == 0)
+	if err != nil {
+		log.Debug().Err(err).Msgf("Upload range rejected: %s", docPath)
+		w.serveWriteError(err, res, req)
+		return
+	}
+	var stagedBytes, stagedFiles int64
+	defer func() { reservation.release(stagedBytes, stagedFiles) }()
+
+	err = os.MkdirAll(filepath.Dir(stagedFilePath), os.ModePerm)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	stagedFile, err := os.OpenFile(stagedFilePath, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+	defer stagedFile.Close()
+	if offset == 0 {
+		stagedFiles = 1
+	}
+
+	_, err = stagedFile.Seek(r.Start, io.SeekStart)
+	if err == nil {
+		var nbytes int64
+		nbytes, err = io.Copy(stagedFile, io.LimitReader(req.Body, length))
+		metrics.WrittenBytes.WithLabelValues(w.Slug).Add(float64(nbytes))
+		if err == nil && nbytes != length {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if info, statErr := stagedFile.Stat(); statErr == nil {
+		stagedBytes = info.Size() - offset
+		offset = info.Size()
+	}
+	res.Header().Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+	if err != nil {
+		log.Debug().Err(err).Msgf("Error copying request body to staged upload: %s", docPath)
+		if err == io.ErrUnexpectedEOF {
+			res.WriteHeader(http.StatusBadRequest)
+			res.Write([]byte("Request body shorter than Content-Range"))
+			return
+		}
+		w.serveWriteError(err, res, req)
+		return
+	}
+	log.Trace().Msgf("Upload range copied to staged upload: %s: %d-%d", docPath, r.Start, r.End)
+
+	if r.Total < 0 || offset < r.Total {
+		res.Header().Set("Range", fmt.Sprintf("bytes=0-%d", offset-1))
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// The last byte is received so the staged upload replaces the document
+	err = stagedFile.Truncate(r.Total)
+	if err == nil && docFileInfo != nil {
+		err = stagedFile.Chmod(docFileInfo.Mode().Perm())
+	}
+	if closeErr := stagedFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = w.validateFile(docPath, stagedFilePath)
+		var schemaErr *schemaError
+		if errors.As(err, &schemaErr) {
+			// The staged upload is discarded since it cannot be resumed
+			if os.Remove(stagedFilePath) == nil {
+				w.updateUsage(-offset, -1)
+			}
+			res.Header().Del(UploadOffsetHeader)
+			w.serveWriteError(err, res, req)
+			return
+		}
+	}
+	docFilePath := filepath.Join(w.Root, filepath.FromSlash(docPath))
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(docFilePath), os.ModePerm)
+	}
+	if err == nil {
+		err = os.Rename(stagedFilePath, docFilePath)
+	}
+	if err != nil {
+		log.Err(err).Msgf("Error finalizing staged upload: %s", docPath)
+		w.serveError(err, res, req)
+		return
+	}
+	log.Debug().Msgf("Upload finalized: %s: %d bytes", docPath, r.Total)
+
+	if offset > r.Total {
+		w.updateUsage(r.Total-offset, 0)
+	}
+	res.Header().Del(UploadOffsetHeader)
+	w.record(req.Context(), req.Method, docPath)
+	if docFileInfo != nil {
+		w.updateUsage(-docFileInfo.Size(), -1)
+		w.notify(EventModify, docPath)
+		res.WriteHeader(http.StatusOK)
+	} else {
+		w.notify(EventCreate, docPath)
+		res.Header().Add("Location", urlpath.Join("/", w.Slug, docPath))
+		res.WriteHeader(http.StatusCreated)
+	}
+}
+
+// serveUpload serves the status of, or cancels, the staged upload of the document
+func (w *Workspace) serveUpload(docPath string, res http.ResponseWriter, req *http.Request) {
+	stagedFilePath := w.stagedFilePath(docPath)
+	unlock := lockFile(stagedFilePath)
+	defer unlock()
+
+	offset, err := w.stagedOffset(docPath)
+	if err != nil {
+		w.serveError(err, res, req)
+		return
+	}
+
+	switch req.Method {
+	case "GET", "HEAD":
+		data, err := json.Marshal(map[string]int64{"offset": offset})
+		if err != nil {
+			w.serveError(err, res, req)
+			return
+		}
+		res.Header().Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "no-store")
+		res.WriteHeader(http.StatusOK)
+		if req.Method != "HEAD" {
+			res.Write(data)
+		}
+
+	case "DELETE":
+		if err := os.Remove(stagedFilePath); err != nil && !os.IsNotExist(err) {
+			w.serveError(err, res, req)
+			return
+		} else if err == nil {
+			w.updateUsage(-offset, -1)
+		}
+		res.WriteHeader(http.StatusNoContent)
+
+	default:
+		res.Header().Set("Allow", "GET, HEAD, DELETE, OPTIONS")
+		w.serveError(http.StatusMethodNotAllowed, res, req)
+	}
+}
+
+// expireUploads removes the staged uploads that have not been written
+// within the expiry until the workspace is cancelled
+func (w *Workspace) expireUploads() {
+	if w.uploads.expiry <= 0 {
+		return
+	}
+	interval := w.uploads.expiry / 2
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.removeExpiredUploads(time.Now().Add(-w.uploads.expiry))
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeExpiredUploads removes the staged uploads last written before the time
+func (w *Workspace) removeExpiredUploads(before time.Time) {
+	uploadsPath := w.statePath(uploadsDir)
+	entries, err := os.ReadDir(uploadsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn().Err(err).Msgf("Staged uploads not read: %s", w.Slug)
+		}
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		stagedFilePath := filepath.Join(uploadsPath, entry.Name())
+		unlock := lockFile(stagedFilePath)
+		info, err := os.Stat(stagedFilePath)
+		if err == nil && info.ModTime().Before(before) {
+			if err := os.Remove(stagedFilePath); err != nil {
+				log.Warn().Err(err).Msgf("Expired upload not removed: %s", stagedFilePath)
+			} else {
+				log.Debug().Msgf("Expired upload removed: %s", stagedFilePath)
+				w.updateUsage(-info.Size(), -1)
+			}
+		}
+		unlock()
+	}
+}
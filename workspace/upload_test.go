@@ -0,0 +1,156 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentRange(t *testing.T) {
+	table := []struct {
+		Value string
+		Range contentRange
+		Valid bool
+	}{
+		{Value: "bytes 0-99/1000", Range: contentRange{Start: 0, End: 99, Total: 1000}, Valid: true},
+		{Value: "bytes 100-199/*", Range: contentRange{Start: 100, End: 199, Total: -1}, Valid: true},
+		{Value: "bytes 0-1000/1000", Valid: false},
+		{Value: "bytes 10-5/100", Valid: false},
+		{Value: "bytes */100", Valid: false},
+		{Value: "items 0-1/2", Valid: false},
+	}
+
+	for _, row := range table {
+		t.Run(row.Value, func(t *testing.T) {
+			r, err := parseContentRange(row.Value)
+			if !row.Valid {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err, err)
+			require.Equal(t, row.Range, r)
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	root := t.TempDir()
+
+	w := New(&testMakeshiftd{}, "test", root, nil)
+	require.Nil(t, w.err, w.err)
+
+	table := []struct {
+		Name   string
+		Method string
+		Path   string
+		Range  string
+		Body   string
+		Code   int
+		Offset string
+	}{
+		{Name: "First", Method: "PUT", Path: "/data.csv", Range: "bytes 0-4/*", Body: "a,b,c", Code: http.StatusAccepted, Offset: "5"},
+		{Name: "Status", Method: "HEAD", Path: "/data.csv?upload", Code: http.StatusOK, Offset: "5"},
+		{Name: "NotStored", Method: "GET", Path: "/data.csv", Code: http.StatusNotFound},
+		{Name: "Gap", Method: "PUT", Path: "/data.csv", Range: "bytes 8-10/11", Body: "2,3", Code: http.StatusConflict, Offset: "5"},
+		{Name: "Short", Method: "PUT", Path: "/data.csv", Range: "bytes 5-10/11", Body: "\n1", Code: http.StatusBadRequest, Offset: "7"},
+		{Name: "Resent", Method: "PUT", Path: "/data.csv", Range: "bytes 3-6/*", Body: ",c\n1", Code: http.StatusAccepted, Offset: "7"},
+		{Name: "Last", Method: "PUT", Path: "/data.csv", Range: "bytes 7-10/11", Body: ",2,3", Code: http.StatusCreated},
+		{Name: "Stored", Method: "GET", Path: "/data.csv", Code: http.StatusOK},
+		{Name: "Finalized", Method: "HEAD", Path: "/data.csv?upload", Code: http.StatusOK, Offset: "0"},
+		{Name: "Cancelled", Method: "PUT", Path: "/other.csv", Range: "bytes 0-1/4", Body: "ab", Code: http.StatusAccepted, Offset: "2"},
+		{Name: "Cancel", Method: "DELETE", Path: "/other.csv?upload", Code: http.StatusNoContent},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			req := httptest.NewRequest(row.Method, row.Path, strings.NewReader(row.Body))
+			if row.Range != "" {
+				req.Header.Set("Content-Range", row.Range)
+			}
+			if row.Name == "Short" {
+				req.ContentLength = -1
+			}
+			res := httptest.NewRecorder()
+			w.ServeHTTP(res, req)
+			require.Equal(t, row.Code, res.Code)
+			if row.Offset != "" {
+				require.Equal(t, row.Offset, res.Header().Get(UploadOffsetHeader))
+			}
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "data.csv"))
+	require.Nil(t, err, err)
+	require.Equal(t, "a,b,c\n1,2,3", string(data))
+
+	entries, err := os.ReadDir(w.statePath(uploadsDir))
+	require.Nil(t, err, err)
+	require.Empty(t, entries)
+	require.Equal(t, Usage{Bytes: 11, Files: 1}, w.Usage())
+
+	fileLocks.mtx.Lock()
+	defer fileLocks.mtx.Unlock()
+	require.Empty(t, fileLocks.locks)
+}
+
+func TestUploadExpiry(t *testing.T) {
+	root := t.TempDir()
+
+	config := viper.New()
+	config.Set("limits.uploadExpiry", "1h")
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+	defer w.Cancel()
+
+	for _, path := range []string{"/old.csv", "/new.csv"} {
+		req := httptest.NewRequest("PUT", path, strings.NewReader("abc"))
+		req.Header.Set("Content-Range", "bytes 0-2/*")
+		res := httptest.NewRecorder()
+		w.ServeHTTP(res, req)
+		require.Equal(t, http.StatusAccepted, res.Code)
+	}
+	require.Equal(t, Usage{Bytes: 6, Files: 2}, w.Usage())
+
+	old := time.Now().Add(-2 * time.Hour)
+	err := os.Chtimes(w.stagedFilePath("/old.csv"), old, old)
+	require.Nil(t, err, err)
+
+	w.removeExpiredUploads(time.Now().Add(-w.uploads.expiry))
+	_, err = os.Stat(w.stagedFilePath("/old.csv"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(w.stagedFilePath("/new.csv"))
+	require.Nil(t, err, err)
+	require.Equal(t, Usage{Bytes: 3, Files: 1}, w.Usage())
+
+	err = w.reconcileUsage()
+	require.Nil(t, err, err)
+	require.Equal(t, Usage{Bytes: 3, Files: 1}, w.Usage())
+}
+
+func TestLockFiles(t *testing.T) {
+	// The files locked in any order by concurrent writes do not deadlock
+	done := make(chan bool)
+	for _, paths := range [][]string{{"/a", "/b"}, {"/b", "/a"}, {"/a", "/a"}} {
+		go func(paths []string) {
+			for i := 0; i < 1000; i++ {
+				unlock := lockFiles(append([]string{}, paths...)...)
+				unlock()
+			}
+			done <- true
+		}(paths)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Locking files deadlocked")
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package workspace
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/makeshiftd/makeshiftd/auth"
+	"github.com/makeshiftd/makeshiftd/metrics"
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+// webdavMethods are the methods served by the WebDAV handler if the
+// WebDAV mode of the workspace is enabled, the documents are written
+// by PUT as without the WebDAV mode
+var webdavMethods = map[string]bool{
+	"PROPFIND":  true,
+	"PROPPATCH": true,
+	"MKCOL":     true,
+	"LOCK":      true,
+	"UNLOCK":    true,
+	"DELETE":    true,
+	"COPY":      true,
+	"MOVE":      true,
+}
+
+// ifTokenRegexp matches the lock tokens of the lists of an If header
+var ifTokenRegexp = regexp.MustCompile(`<([^>]*)>`)
+
+// confirmLocks confirms that the document may be written by the request,
+// the document must not be locked or the If header must have the token of
+// the lock, the lock is held until the release function is called
+func (w *Workspace) confirmLocks(docPath string, req *http.Request) (func(), int) {
+	name := urlpath.Join("/", docPath)
+	now := time.Now()
+
+	header := req.Header.Get("If")
+	if header == "" {
+		// A temporary lock conflicts with the locks of other clients
+		token, err := w.locks.Create(now, webdav.LockDetails{Root: name, Duration: -1, ZeroDepth: true})
+		if err == webdav.ErrLocked {
+			return nil, http.StatusLocked
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError
+		}
+		return func() { w.locks.Unlock(now, token) }, 0
+	}
+
+	conditions := []webdav.Condition{}
+	for _, list := range strings.Split(header, "(")[1:] {
+		list = strings.SplitN(list, ")", 2)[0]
+		for _, match := range ifTokenRegexp.FindAllStringSubmatch(list, -1) {
+			conditions = append(conditions, webdav.Condition{Token: match[1]})
+		}
+	}
+	release, err := w.locks.Confirm(now, name, "", conditions...)
+	if err != nil {
+		return nil, http.StatusPreconditionFailed
+	}
+	return release, 0
+}
+
+// serveWebDAV serves the document with the WebDAV handler, the document
+// path has been resolved and authorized as for any other request
+func (w *Workspace) serveWebDAV(docPath string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+
+	switch req.Method {
+	case "COPY", "MOVE":
+		dest, destPath, code := w.destination(req)
+		if code == 0 && dest != w {
+			code = http.StatusBadGateway
+		}
+		if code != 0 {
+			log.Debug().Msgf("Destination invalid: %s", req.Header.Get("Destination"))
+			w.serveError(code, res, req)
+			return
+		}
+		principal := auth.Ctx(req.Context())
+		allowed, err := w.authorize(principal, "PUT", destPath)
+		if err != nil {
+			log.Warn().Err(err).Msg("Access rules evaluation failed")
+			w.serveError(err, res, req)
+			return
+		}
+		if !allowed {
+			log.Debug().Msgf("Access denied: %s PUT %s", principal.Name, destPath)
+			w.serveError(http.StatusForbidden, res, req)
+			return
+		}
+		// The files are written by the WebDAV handler without validation
+		srcFilePath := filepath.Join(w.Root, filepath.FromSlash(docPath))
+		if srcInfo, err := os.Stat(srcFilePath); err == nil {
+			if !w.authorizeCopy(docPath, srcFilePath, srcInfo, w, destPath, res, req) {
+				return
+			}
+			if err := w.validateTree(srcFilePath, srcInfo, destPath); err != nil {
+				log.Debug().Err(err).Msgf("WebDAV %s rejected: %s", req.Method, destPath)
+				w.serveWriteError(err, res, req)
+				return
+			}
+		}
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     "/" + w.Slug,
+		FileSystem: &webdavFS{w: w, principal: auth.Ctx(req.Context())},
+		LockSystem: w.locks,
+		Logger: func(req *http.Request, err error) {
+			if err != nil {
+				log.Debug().Err(err).Msgf("WebDAV %s failed: %s", req.Method, docPath)
+			}
+		},
+	}
+	req.URL.Path = urlpath.Join("/", w.Slug, docPath)
+	handler.ServeHTTP(res, req)
+}
+
+// webdavFS is the file system of the workspace for the WebDAV handler,
+// the hidden files and the files the principal may not read are not
+// found, and the usage of the workspace is updated by the writes
+type webdavFS struct {
+	w         *Workspace
+	principal auth.Principal
+}
+
+// resolve returns the file path of the name, or an error if the name is hidden
+func (fs *webdavFS) resolve(name string) (string, error) {
+	segments, exec, ok := docSegments(name)
+	if !ok || exec {
+		return "", os.ErrNotExist
+	}
+	return filepath.Join(fs.w.Root, filepath.FromSlash(urlpath.Join(segments...))), nil
+}
+
+func (fs *webdavFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	path, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(path, perm)
+	if err == nil {
+		fs.w.notify(EventCreate, name)
+	}
+	return err
+}
+
+func (fs *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	path, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		f, err := os.OpenFile(path, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return &webdavFile{file: f, fs: fs, name: name}, nil
+	}
+
+	var size int64
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	created := err != nil
+	if created {
+		if err := fs.w.addUsage(0, 1); err != nil {
+			return nil, err
+		}
+	} else if flag&os.O_TRUNC == 0 {
+		size = info.Size()
+	}
+
+	f, err := os.OpenFile(path, flag, perm)
+	if err != nil {
+		if created {
+			fs.w.updateUsage(0, -1)
+		}
+		return nil, err
+	}
+	if !created && flag&os.O_TRUNC != 0 {
+		fs.w.updateUsage(-info.Size(), 0)
+	}
+	return &webdavFile{file: f, fs: fs, ctx: ctx, name: name, size: size, write: true, created: created}, nil
+}
+
+func (fs *webdavFS) RemoveAll(ctx context.Context, name string) error {
+	path, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	if path == fs.w.Root {
+		return os.ErrPermission
+	}
+	bytes, files, err := treeUsage(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.RemoveAll(path)
+	if err != nil {
+		// The usage is uncertain after a partial removal
+		fs.w.reconcileUsage()
+		return err
+	}
+	fs.w.updateUsage(-bytes, -files)
+	fs.w.record(ctx, "DELETE", name)
+	fs.w.notify(EventDelete, name)
+	return nil
+}
+
+func (fs *webdavFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, err := fs.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := fs.resolve(newName)
+	if err != nil {
+		return err
+	}
+	if oldPath == fs.w.Root {
+		return os.ErrPermission
+	}
+	err = os.Rename(oldPath, newPath)
+	if err == nil {
+		fs.w.record(ctx, "MOVE", oldName, newName)
+		fs.w.notify(EventDelete, oldName)
+		fs.w.notify(EventCreate, newName)
+	}
+	return err
+}
+
+func (fs *webdavFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	path, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+// webdavFile is a file of the workspace opened by the WebDAV handler,
+// the size of the written file is tracked to update the usage and the
+// context of the request is kept to record the history, the file is not
+// embedded so that the writes, including by io.Copy, check the quota
+type webdavFile struct {
+	file    *os.File
+	fs      *webdavFS
+	ctx     context.Context
+	name    string
+	size    int64
+	write   bool
+	created bool
+}
+
+func (f *webdavFile) Write(p []byte) (int, error) {
+	if err := f.fs.w.addUsage(int64(len(p)), 0); err != nil {
+		return 0, err
+	}
+	n, err := f.file.Write(p)
+	if n < len(p) {
+		f.fs.w.updateUsage(int64(n-len(p)), 0)
+	}
+	f.size += int64(n)
+	metrics.WrittenBytes.WithLabelValues(f.fs.w.Slug).Add(float64(n))
+	return n, err
+}
+
+func (f *webdavFile) Read(p []byte) (int, error) {
+	return f.file.Read(p)
+}
+
+func (f *webdavFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+func (f *webdavFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+func (f *webdavFile) Close() error {
+	if !f.write {
+		return f.file.Close()
+	}
+	// Writes may overwrite the existing content of the file
+	if info, err := f.file.Stat(); err == nil && info.Size() != f.size {
+		f.fs.w.updateUsage(info.Size()-f.size, 0)
+	}
+	err := f.file.Close()
+	f.fs.w.record(f.ctx, "PUT", f.name)
+	if f.created {
+		f.fs.w.notify(EventCreate, f.name)
+	} else {
+		f.fs.w.notify(EventModify, f.name)
+	}
+	return err
+}
+
+// Readdir returns the entries of the directory without the
+// hidden entries and those the principal may not read
+func (f *webdavFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.file.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		docPath := urlpath.Join(f.name, name)
+		if allowed, authErr := f.fs.w.authorize(f.fs.principal, "GET", docPath); authErr != nil || !allowed {
+			continue
+		}
+		visible = append(visible, info)
+	}
+	return visible, err
+}
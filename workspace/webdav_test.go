@@ -0,0 +1,182 @@
+package workspace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"github.com/studio-b12/gowebdav"
+
+	"github.com/makeshiftd/makeshiftd/urlpath"
+)
+
+func TestWebDAV(t *testing.T) {
+	root := t.TempDir()
+
+	config := viper.New()
+	config.Set("webdav.enabled", true)
+
+	err := os.WriteFile(filepath.Join(root, AccessFileName), []byte(`{
+		"rules": [{ "paths": ["private/**"], "principals": ["*"], "effect": "deny" }]
+	}`), os.ModePerm)
+	require.Nil(t, err, err)
+	err = os.MkdirAll(filepath.Join(root, "private"), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, req.URL.Path = urlpath.PopLeft(req.URL.Path)
+		w.ServeHTTP(res, req)
+	}))
+	defer server.Close()
+
+	client := gowebdav.NewClient(server.URL+"/test", "", "")
+
+	err = client.Mkdir("docs", os.ModePerm)
+	require.Nil(t, err, err)
+	err = client.Write("docs/a.txt", []byte("hello"), os.ModePerm)
+	require.Nil(t, err, err)
+	err = client.Copy("docs/a.txt", "docs/b.txt", false)
+	require.Nil(t, err, err)
+	err = client.Rename("docs/b.txt", "docs/c.txt", false)
+	require.Nil(t, err, err)
+
+	infos, err := client.ReadDir("docs")
+	require.Nil(t, err, err)
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{"a.txt", "c.txt"}, names)
+
+	infos, err = client.ReadDir("/")
+	require.Nil(t, err, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "docs", infos[0].Name())
+
+	data, err := client.Read("docs/c.txt")
+	require.Nil(t, err, err)
+	require.Equal(t, "hello", string(data))
+
+	err = client.Copy("docs/a.txt", "_hidden.txt", false)
+	require.NotNil(t, err)
+	_, err = client.Stat("private")
+	require.NotNil(t, err)
+
+	// Lock the document and check that writes require the lock token
+	req, err := http.NewRequest("LOCK", server.URL+"/test/docs/a.txt", strings.NewReader(`<?xml version="1.0" encoding="utf-8"?>
+		<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`))
+	require.Nil(t, err, err)
+	res, err := http.DefaultClient.Do(req)
+	require.Nil(t, err, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	token := res.Header.Get("Lock-Token")
+	require.NotEmpty(t, token)
+
+	err = client.Write("docs/a.txt", []byte("changed"), os.ModePerm)
+	require.NotNil(t, err)
+
+	req, err = http.NewRequest("PUT", server.URL+"/test/docs/a.txt", strings.NewReader("changed"))
+	require.Nil(t, err, err)
+	req.Header.Set("If", "("+token+")")
+	res, err = http.DefaultClient.Do(req)
+	require.Nil(t, err, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	err = client.Remove("docs/a.txt")
+	require.NotNil(t, err)
+
+	req, err = http.NewRequest("UNLOCK", server.URL+"/test/docs/a.txt", nil)
+	require.Nil(t, err, err)
+	req.Header.Set("Lock-Token", token)
+	res, err = http.DefaultClient.Do(req)
+	require.Nil(t, err, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	err = client.RemoveAll("docs")
+	require.Nil(t, err, err)
+
+	usage := w.Usage()
+	require.Nil(t, w.reconcileUsage())
+	require.Equal(t, w.Usage(), usage)
+}
+
+func TestWebDAVUpload(t *testing.T) {
+	root := t.TempDir()
+
+	config := viper.New()
+	config.Set("webdav.enabled", true)
+
+	err := os.WriteFile(filepath.Join(root, "doc.txt"), []byte("the original document content"), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	// The resumable uploads are served as without the WebDAV mode
+	req := httptest.NewRequest("PUT", "/doc.txt", strings.NewReader("abc"))
+	req.Header.Set("Content-Range", "bytes 0-2/10")
+	res := httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.Equal(t, http.StatusAccepted, res.Code)
+	require.Equal(t, "3", res.Header().Get(UploadOffsetHeader))
+
+	req = httptest.NewRequest("DELETE", "/doc.txt?upload", nil)
+	res = httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.Equal(t, http.StatusNoContent, res.Code)
+
+	data, err := os.ReadFile(filepath.Join(root, "doc.txt"))
+	require.Nil(t, err, err)
+	require.Equal(t, "the original document content", string(data))
+
+	req = httptest.NewRequest("DELETE", "/doc.txt", nil)
+	res = httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.Equal(t, http.StatusNoContent, res.Code)
+	_, err = os.Stat(filepath.Join(root, "doc.txt"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestWebDAVQuota(t *testing.T) {
+	root := t.TempDir()
+
+	config := viper.New()
+	config.Set("webdav.enabled", true)
+	config.Set("quota.maxBytes", 100)
+
+	err := os.WriteFile(filepath.Join(root, "doc.txt"), []byte(strings.Repeat("x", 60)), os.ModePerm)
+	require.Nil(t, err, err)
+
+	w := New(&testMakeshiftd{}, "test", root, config)
+	require.Nil(t, w.err, w.err)
+
+	// A chunked body is limited while it is written
+	req := httptest.NewRequest("PUT", "/big.txt", strings.NewReader(strings.Repeat("x", 5000)))
+	req.ContentLength = -1
+	res := httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.Equal(t, http.StatusInsufficientStorage, res.Code)
+
+	// The files copied by the WebDAV handler are limited by the writes
+	req = httptest.NewRequest("COPY", "/doc.txt", nil)
+	req.Header.Set("Destination", "/test/copy.txt")
+	res = httptest.NewRecorder()
+	w.ServeHTTP(res, req)
+	require.GreaterOrEqual(t, res.Code, http.StatusBadRequest)
+
+	require.Nil(t, w.reconcileUsage())
+	require.LessOrEqual(t, w.Usage().Bytes, int64(100))
+}
@@ -11,14 +11,26 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/spf13/viper"
+	"golang.org/x/net/webdav"
+
+	"github.com/makeshiftd/makeshiftd/auth"
 	"github.com/makeshiftd/makeshiftd/context"
+	"github.com/makeshiftd/makeshiftd/cors"
 	"github.com/makeshiftd/makeshiftd/loggers"
+	"github.com/makeshiftd/makeshiftd/metrics"
+	"github.com/makeshiftd/makeshiftd/request"
 	"github.com/makeshiftd/makeshiftd/urlpath"
 )
 
 var log = loggers.NewLazyLoggerPkg("workspace")
 
+// StateDir is the hidden directory in the root of the workspace
+// in which the state of the workspace is stored
+const StateDir = ".makeshiftd"
+
 type Makeshiftd interface {
 	Workspaces() []*Workspace
 	ServeError(cause interface{}, res http.ResponseWriter, req *http.Request)
@@ -30,27 +42,92 @@ type Workspace struct {
 	Slug string
 	Root string
 
-	m      Makeshiftd
-	err    error
-	ctx    context.C
-	cancel context.CancelFunc
+	m           Makeshiftd
+	config      *viper.Viper
+	access      AccessConfig
+	cors        *cors.Policy
+	maxBodySize int64
+	quota       quota
+	locks       webdav.LockSystem
+	events      events
+	uploads     uploads
+	templates   templates
+	collections *collections
+	schemas     schemas
+	search      search
+	history     *history
+	liveReload  bool
+	reloadExec  bool
+	err         error
+	ctx         context.C
+	cancel      context.CancelFunc
+}
+
+// apis are the workspace APIs served at the root of the workspace
+var apis = map[string]func(w *Workspace, res http.ResponseWriter, req *http.Request){
+	UsageAPI:    (*Workspace).serveUsage,
+	EventsAPI:   (*Workspace).serveEvents,
+	ValidateAPI: (*Workspace).serveValidate,
+	SearchAPI:   (*Workspace).serveSearch,
+	HistoryAPI:  (*Workspace).serveHistory,
 }
 
 // New creates a new workspace for the given Makeshitfd service
-func New(m Makeshiftd, name, root string) *Workspace {
+func New(m Makeshiftd, name, root string, config *viper.Viper) *Workspace {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	slug := strings.ToLower(name)
 
+	if config == nil {
+		config = viper.New()
+	}
+
 	w := &Workspace{
 		Name:   name,
 		Slug:   slug,
 		Root:   root,
 		m:      m,
+		config: config,
 		ctx:    ctx,
 		cancel: cancel,
 	}
 
+	if err := config.UnmarshalKey("access", &w.access); err != nil {
+		w.err = fmt.Errorf("Workspace access configuration invalid: %w", err)
+	} else if err := w.access.validate(); err != nil {
+		w.err = fmt.Errorf("Workspace access configuration invalid: %w", err)
+	}
+
+	if err := config.UnmarshalKey("schemas", &w.schemas.rules); err != nil {
+		w.err = fmt.Errorf("Workspace schemas configuration invalid: %w", err)
+	}
+
+	if config.IsSet("cors") {
+		w.cors = &cors.Policy{}
+		if err := config.UnmarshalKey("cors", w.cors); err != nil {
+			w.err = fmt.Errorf("Workspace CORS configuration invalid: %w", err)
+		}
+	}
+
+	if config.GetBool("collections.enabled") {
+		w.collections = &collections{}
+	}
+
+	if config.GetBool("webdav.enabled") {
+		w.locks = webdav.NewMemLS()
+	}
+
+	w.liveReload = config.GetBool("dev.liveReload")
+	w.reloadExec = w.liveReload && config.GetBool("dev.reloadExec")
+
+	w.maxBodySize = int64(config.GetSizeInBytes("limits.maxBodySize"))
+	w.quota.maxBytes = int64(config.GetSizeInBytes("quota.maxBytes"))
+	w.quota.maxFiles = config.GetInt64("quota.maxFiles")
+	w.uploads.expiry = defaultUploadExpiry
+	if config.IsSet("limits.uploadExpiry") {
+		w.uploads.expiry = config.GetDuration("limits.uploadExpiry")
+	}
+
 	for _, workspace := range m.Workspaces() {
 		if slug == workspace.Slug {
 			w.err = fmt.Errorf("Workspace slug is not unique")
@@ -65,9 +142,50 @@ func New(m Makeshiftd, name, root string) *Workspace {
 		w.err = fmt.Errorf("Workspace root not found")
 	}
 
+	if w.err == nil && config.GetBool("history.enabled") {
+		history, err := w.openHistory()
+		if err != nil {
+			w.err = fmt.Errorf("Workspace history failed: %w", err)
+		}
+		w.history = history
+	}
+
+	if w.err == nil {
+		if err := w.reconcileUsage(); err != nil {
+			log.Warn().Err(err).Msgf("Workspace usage reconciliation failed: %s", name)
+		}
+		// The uploads staged before a restart also expire
+		if _, err := os.Stat(w.statePath(uploadsDir)); err == nil {
+			w.uploads.expireOnce.Do(func() {
+				go w.expireUploads()
+			})
+		}
+	}
+
 	return w
 }
 
+// Check returns an error if the workspace is not configured correctly
+// or if the root directory of the workspace is not accessible
+func (w *Workspace) Check() error {
+	if w.err != nil {
+		return w.err
+	}
+	info, err := os.Stat(w.Root)
+	if err != nil {
+		return fmt.Errorf("Workspace root not accessible")
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Workspace root is not a directory")
+	}
+	return nil
+}
+
+// statePath returns the path of the file in the state directory of the workspace
+func (w *Workspace) statePath(elem ...string) string {
+	return filepath.Join(append([]string{w.Root, StateDir}, elem...)...)
+}
+
 // Cancel cancels this workspace and all associated requests
 func (w *Workspace) Cancel() {
 	w.cancel()
@@ -78,10 +196,85 @@ func (w *Workspace) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	// ctx, cancel := context.Merge(req.Context(), w.ctx)
 	// defer cancel()
 
-	exec := false
+	ctxLog := loggers.Ctx(req.Context()).With().Str("ws", w.Slug).Logger()
+	req = req.WithContext(ctxLog.WithContext(req.Context()))
+	log := log.Ctx(req.Context())
+
 	path := req.URL.Path
 	log.Debug().Msgf("Serve HTTP slug: %s, path: %s", w.Slug, path)
 
+	// The workspace APIs are served at the root of the workspace
+	api, apiPath := urlpath.PopLeft(path)
+	if apis[api] == nil {
+		api = ""
+	}
+
+	exec := false
+	docPath := api
+	if api == "" {
+		segments, isExec, ok := docSegments(path)
+		if !ok {
+			w.serveError(http.StatusNotFound, res, req)
+			return
+		}
+		exec = isExec
+		docPath = urlpath.Join(segments...)
+		apiPath = ""
+	}
+	req.URL.Path = apiPath
+
+	info := request.Ctx(req.Context())
+	info.Workspace = w.Slug
+	info.Exec = exec
+
+	if w.cors != nil && w.cors.Handle(res, req) {
+		log.Debug().Msgf("CORS preflight: %s", req.Header.Get("Origin"))
+		return
+	}
+
+	if req.Method != "OPTIONS" {
+		principal := auth.Ctx(req.Context())
+		method := accessMethod(req.Method, exec)
+		allowed, err := w.authorize(principal, method, docPath)
+		if err != nil {
+			log.Warn().Err(err).Msg("Access rules evaluation failed")
+			w.serveError(err, res, req)
+			return
+		}
+		if !allowed {
+			log.Debug().Msgf("Access denied: %s %s %s", principal.Name, method, docPath)
+			w.serveError(http.StatusForbidden, res, req)
+			return
+		}
+	}
+
+	if w.maxBodySize > 0 && req.Body != nil {
+		if req.ContentLength > w.maxBodySize {
+			log.Debug().Msgf("Request body too large: %d bytes", req.ContentLength)
+			w.serveError(http.StatusRequestEntityTooLarge, res, req)
+			return
+		}
+		req.Body = http.MaxBytesReader(res, req.Body, w.maxBodySize)
+	}
+
+	if api != "" {
+		apis[api](w, res, req)
+	} else if w.locks != nil && !exec && webdavMethods[req.Method] && !jsonQuery(req) && !uploadQuery(req) {
+		w.serveWebDAV(docPath, res, req)
+	} else if req.Method == "OPTIONS" {
+		w.serveOptions(docPath, exec, res, req)
+	} else if exec {
+		w.execDoc(docPath, res, req)
+	} else {
+		w.serveDoc(docPath, res, req)
+	}
+}
+
+// docSegments resolves the dot segments of the document path, a segment prefixed
+// with '!' makes the path an exec path, the path is not valid if it contains
+// a hidden segment, prefixed with '.' or '_', or is outside of the root
+func docSegments(path string) ([]string, bool, bool) {
+	exec := false
 	segment := ""
 	segments := []string{}
 	for {
@@ -98,12 +291,10 @@ func (w *Workspace) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 				segments = segments[:len(segments)-1]
 				continue
 			}
-			fallthrough
-		case strings.HasPrefix(segment, "."):
-			fallthrough
-		case strings.HasPrefix(segment, "_"):
-			w.serveError(http.StatusNotFound, res, req)
-			return
+			return nil, false, false
+
+		case strings.HasPrefix(segment, "."), strings.HasPrefix(segment, "_"):
+			return nil, false, false
 
 		case strings.HasPrefix(segment, "!"):
 			segment = segment[1:]
@@ -111,27 +302,21 @@ func (w *Workspace) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		}
 		segments = append(segments, segment)
 	}
-
-	//segments = append([]string{w.Root}, segments...)
-	req.URL.Path = path
-	docPath := urlpath.Join(segments...)
-	if exec {
-		w.execDoc(docPath, res, req)
-	} else {
-		w.serveDoc(docPath, res, req)
-	}
+	return segments, exec, true
 }
 
 func (w *Workspace) serveError(cause interface{}, res http.ResponseWriter, req *http.Request) {
 	w.m.ServeError(cause, res, req)
 }
 
-var executers = []struct {
+type executer struct {
 	Ext  string
 	Env  string
 	Cmd  string
 	Args []string
-}{
+}
+
+var executers = []executer{
 	{
 		Ext:  ".go",
 		Cmd:  "go",
@@ -139,26 +324,36 @@ var executers = []struct {
 	},
 }
 
-func (w *Workspace) execDoc(docPath string, res http.ResponseWriter, req *http.Request) {
-	log.Debug().Msgf("Exec file path: %s", docPath)
+// execMethods are the methods supported by exec documents
+var execMethods = []string{"GET", "HEAD", "POST"}
 
-	var exeDocPath string
-	var exeCommand string
-	var exeArguments []string
-	for _, executer := range executers {
-		exeDocPath := docPath + executer.Ext
-		exeDocInfo, err := os.Stat(exeDocPath)
+// findExecDoc returns the file path and executer of the exec document,
+// the file path is empty if the exec document is not found
+func (w *Workspace) findExecDoc(docPath string) (string, *executer, error) {
+	for idx := range executers {
+		path := filepath.Join(w.Root, filepath.FromSlash(docPath)) + executers[idx].Ext
+		info, err := os.Stat(path)
 		if err != nil && !os.IsNotExist(err) {
-			w.serveError(http.StatusInternalServerError, res, req)
-			return
+			return "", nil, err
 		}
-		if exeDocInfo.IsDir() {
+		if err != nil || info.IsDir() {
 			continue
 		}
-		exeCommand = executer.Cmd
-		exeArguments = executer.Args[:]
+		return path, &executers[idx], nil
 	}
-	if exeCommand == "" {
+	return "", nil, nil
+}
+
+func (w *Workspace) execDoc(docPath string, res http.ResponseWriter, req *http.Request) {
+	log := log.Ctx(req.Context())
+	log.Debug().Msgf("Exec file path: %s", docPath)
+
+	exeDocPath, executer, err := w.findExecDoc(docPath)
+	if err != nil {
+		w.serveError(http.StatusInternalServerError, res, req)
+		return
+	}
+	if exeDocPath == "" {
 		w.serveError(http.StatusNotFound, res, req)
 		return
 	}
@@ -168,41 +363,57 @@ func (w *Workspace) execDoc(docPath string, res http.ResponseWriter, req *http.R
 		contentType = "application/octet-stream"
 	}
 
+	switch req.Method {
+	case "GET", "POST":
+	case "HEAD":
+		// The metadata of the exec document is served without running it
+		if info, err := os.Stat(exeDocPath); err == nil {
+			res.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+		res.Header().Set("Content-Type", contentType)
+		res.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.serveMethodNotAllowed(docPath, true, res, req)
+		return
+	}
+
+	exeCommand := executer.Cmd
+	exeArguments := append([]string{}, executer.Args...)
 	exeArguments = append(exeArguments, exeDocPath)
 
 	cmd := exec.CommandContext(req.Context(), exeCommand, exeArguments...)
+	cmd.Env = append(os.Environ(), "MAKESHIFTD_REQUEST_ID="+request.Ctx(req.Context()).ID)
 
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		w.serveError(http.StatusInternalServerError, res, req)
-		return
-	}
+	// The output is buffered so that the status can be set if the command fails
 	stderr := &bytes.Buffer{}
-	go func() {
-		io.Copy(stderr, stderrPipe)
-	}()
-	// TODO Work Group!
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		w.serveError(http.StatusInternalServerError, res, req)
-		return
-	}
+	cmd.Stderr = stderr
 	stdout := &bytes.Buffer{}
-	go func() {
-		io.Copy(stdout, stdoutPipe)
-	}()
+	cmd.Stdout = stdout
 
+	start := time.Now()
 	err = cmd.Start()
 	if err != nil {
+		metrics.Execs.WithLabelValues(exeCommand, "error").Inc()
 		w.serveError(http.StatusInternalServerError, res, req)
 		return
 	}
 
 	err = cmd.Wait()
+	metrics.ExecDuration.WithLabelValues(exeCommand).Observe(time.Since(start).Seconds())
 	if err != nil {
+		log.Debug().Err(err).Msgf("Exec command failed: %s", stderr.String())
+		metrics.Execs.WithLabelValues(exeCommand, "error").Inc()
 		w.serveError(http.StatusInternalServerError, res, req)
 		return
 	}
+	metrics.Execs.WithLabelValues(exeCommand, "success").Inc()
+
+	if w.reloadExec && isHTML(contentType) {
+		injected := w.injectLiveReload(stdout.Bytes())
+		stdout = bytes.NewBuffer(injected)
+		res.Header().Set("Cache-Control", "no-cache")
+	}
 
 	res.Header().Set("Content-Length", strconv.Itoa(stdout.Len()))
 	res.Header().Set("Content-Type", contentType)